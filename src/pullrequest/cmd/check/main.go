@@ -17,7 +17,7 @@ func main() {
 	req := r.NewCheckRequest()
 	util.InputRequest(&req)
 
-	github, err := r.NewGithubClient(req.Source)
+	github, err := r.NewGithub(req.Source)
 	if err != nil {
 		log.Fatalf("contstructing github client: %+v", err)
 	}
@@ -23,7 +23,7 @@ func main() {
 
 	sourceDir := os.Args[1]
 
-	github, err := r.NewGithubClient(req.Source)
+	github, err := r.NewGithub(req.Source)
 	if err != nil {
 		log.Fatalf("constructing github client: %+v", err)
 	}
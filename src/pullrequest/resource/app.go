@@ -0,0 +1,165 @@
+package resource
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+// mediaTypeAppsPreview is needed for the API to hand out installation tokens.
+const mediaTypeAppsPreview = "application/vnd.github.machine-man-preview+json"
+
+// usesApp tells whether source authenticates as a GitHub App installation
+// rather than with an access token.
+func usesApp(source Source) (bool, error) {
+	if source.AppID == 0 && source.InstallationID == 0 && source.PrivateKey == "" {
+		return false, nil
+	}
+
+	if source.AppID == 0 || source.InstallationID == 0 || source.PrivateKey == "" {
+		return false, errors.New("app_id, installation_id and private_key must be set together")
+	}
+
+	if source.AccessToken != "" {
+		return false, errors.New("access_token cannot be set together with app_id")
+	}
+	return true, nil
+}
+
+// newAppClient returns a client authenticating as the app itself, with a JWT
+// signed with its private key. The JWT expires after nine minutes, the client
+// is only good for asking about the app and its installations.
+func newAppClient(httpClient *http.Client, source Source) (*github.Client, error) {
+	key, err := parsePrivateKey(source.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	jwt, err := appJWT(source.AppID, key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return newAPIClient(source, &http.Client{
+		Transport: &oauth2.Transport{
+			Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: jwt}),
+			Base:   httpClient.Transport,
+		},
+	})
+}
+
+// installationToken exchanges the JWT of the app client for a token of its
+// installation, which works for the API and git alike. Tokens expire after
+// an hour, longer than any get or put takes.
+func installationToken(client *github.Client, installationID int64) (string, error) {
+	u := fmt.Sprintf("app/installations/%d/access_tokens", installationID)
+	req, err := client.NewRequest("POST", u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", mediaTypeAppsPreview)
+
+	token := new(github.InstallationToken)
+	resp, err := client.Do(context.TODO(), req, token)
+	if err != nil {
+		return "", fmt.Errorf("getting token of installation %d: %+v", installationID, err)
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return "", fmt.Errorf("closing resp body: %+v", err)
+	}
+
+	if token.GetToken() == "" {
+		return "", fmt.Errorf("getting token of installation %d: no token returned", installationID)
+	}
+	return token.GetToken(), nil
+}
+
+// appLogin returns the login of the bot user the app acts as, which GitHub
+// derives from the slug of the app.
+func appLogin(client *github.Client) (string, error) {
+	req, err := client.NewRequest("GET", "app", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", mediaTypeAppsPreview)
+
+	app := new(struct {
+		Slug string `json:"slug"`
+	})
+	resp, err := client.Do(context.TODO(), req, app)
+	if err != nil {
+		return "", fmt.Errorf("getting app: %+v", err)
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return "", fmt.Errorf("closing resp body: %+v", err)
+	}
+
+	if app.Slug == "" {
+		return "", errors.New("getting app: no slug returned")
+	}
+	return app.Slug + "[bot]", nil
+}
+
+func parsePrivateKey(privateKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, errors.New("parsing private_key: no PEM encoded key found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private_key: %+v", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("parsing private_key: not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// appJWT returns a JWT identifying the app, signed with RS256 as GitHub
+// requires. It is backdated a minute to allow for clock drift and expires
+// well within the ten minutes GitHub allows.
+func appJWT(appID int64, key *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("signing jwt: %+v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
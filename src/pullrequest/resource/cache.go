@@ -0,0 +1,190 @@
+package resource
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultCacheDir survives between checks, which run in the same container
+// for as long as the resource config does not change.
+var defaultCacheDir = filepath.Join(os.TempDir(), "pullrequest-resource-cache")
+
+// cacheMaxAge is how long a response is kept after it was last used, most
+// of them belong to pulls that were closed long ago by then.
+const cacheMaxAge = 7 * 24 * time.Hour
+
+// cacheTransport keeps the responses to GET requests on disk and makes the
+// requests conditional on them having changed. GitHub answers with 304 Not
+// Modified then, which does not count against the rate limit, and the kept
+// response is returned instead.
+//
+// Every response lives in a file of its own, named by a hash of the request
+// and the credentials it was made with. Files are replaced by renaming, so
+// checks sharing the directory never see a half written one. Responses not
+// used for cacheMaxAge are removed whenever a transport is made. Failing to
+// use the cache never fails a request.
+type cacheTransport struct {
+	base  http.RoundTripper
+	dir   string
+	token string
+}
+
+func newCacheTransport(base http.RoundTripper, dir, token string) *cacheTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	pruneCache(dir, time.Now().Add(-cacheMaxAge))
+	return &cacheTransport{base: base, dir: dir, token: token}
+}
+
+// pruneCache removes the files in dir last used before cutoff. Other checks
+// may be using the directory at the same time, a response they store while
+// it is pruned is at worst fetched again.
+func pruneCache(dir string, cutoff time.Time) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("pruning cache: %+v", err)
+		}
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !file.ModTime().Before(cutoff) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, file.Name())); err != nil && !os.IsNotExist(err) {
+			log.Warnf("pruning cache: %+v", err)
+		}
+	}
+}
+
+// RoundTrip is
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		return t.base.RoundTrip(req)
+	}
+
+	file := t.file(req)
+	cached := readCachedResponse(file, req)
+	if cached != nil {
+		conditional := *req
+		conditional.Header = cloneHeader(req.Header)
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			conditional.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := cached.Header.Get("Last-Modified"); lastModified != "" {
+			conditional.Header.Set("If-Modified-Since", lastModified)
+		}
+		req = &conditional
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+
+		// Keep responses that are still used from being pruned.
+		now := time.Now()
+		if err := os.Chtimes(file, now, now); err != nil && !os.IsNotExist(err) {
+			log.Warnf("touching cached response to %s: %+v", req.URL.Path, err)
+		}
+
+		// The rate limit headers and the like are only current on the 304.
+		for name, values := range resp.Header {
+			cached.Header[name] = values
+		}
+		return cached, nil
+	}
+
+	if resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != "") {
+		if err := t.store(file, resp); err != nil {
+			log.Warnf("caching response to %s: %+v", req.URL.Path, err)
+		}
+	}
+	return resp, nil
+}
+
+// file returns the path of the response to req, which depends on the
+// representation asked for and who asked.
+func (t *cacheTransport) file(req *http.Request) string {
+	hash := sha256.New()
+	for _, part := range []string{t.token, req.URL.String(), req.Header.Get("Accept"), req.Header.Get("Authorization")} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return filepath.Join(t.dir, hex.EncodeToString(hash.Sum(nil)))
+}
+
+// store writes resp to file and replaces its body, which is read in the
+// process, with the stored one.
+func (t *cacheTransport) store(file string, resp *http.Response) error {
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(t.dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(t.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(dump); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func readCachedResponse(file string, req *http.Request) *http.Response {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(f), req)
+	if err != nil {
+		f.Close()
+		log.Warnf("reading cached response to %s: %+v", req.URL.Path, err)
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	f.Close()
+	if err != nil {
+		log.Warnf("reading cached response to %s: %+v", req.URL.Path, err)
+		return nil
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header))
+	for name, values := range header {
+		clone[name] = append([]string(nil), values...)
+	}
+	return clone
+}
@@ -1,7 +1,9 @@
 package resource
 
 import (
-	"strconv"
+	"regexp"
+	"sort"
+	"time"
 )
 
 // CheckCommand is
@@ -14,29 +16,173 @@ func NewCheckCommand(g Github) *CheckCommand {
 	return &CheckCommand{g}
 }
 
-// Run is
+type sortedVersion struct {
+	version Version
+	key     time.Time
+	pull    *Pull
+}
+
+// Run returns the given version while it is still current followed by the
+// versions sorting strictly after it. Versions carry their sort key, so this
+// works as well when the pull of the given version was closed or pushed to
+// in the meantime. Sort keys are only as late as GitHub tells a head was
+// pushed, which for plain pushes is when its commit was made. Pulls updated
+// after the given version are returned as well, right after it, so commits
+// made before it but pushed after it are not lost. Pulls that were only
+// commented on return a version that exists already, which is not built
+// again.
 func (cc *CheckCommand) Run(request CheckRequest) ([]Version, error) {
 	versions := []Version{}
 
+	trigger, err := versionTrigger(request.Source)
+	if err != nil {
+		return versions, err
+	}
+
+	pattern, err := commentTrigger(request.Source)
+	if err != nil {
+		return versions, err
+	}
+
 	pulls, err := cc.github.ListPRs()
 	if err != nil {
 		return versions, err
 	}
 
+	pulls, err = filterPulls(cc.github, request.Source, pulls)
+	if err != nil {
+		return versions, err
+	}
+
 	if len(pulls) == 0 {
 		return versions, nil
 	}
 
-	for i := len(pulls) - 1; i >= 0; i-- {
-		version := Version{
-			Ref: pulls[i].Ref,
-			PR:  strconv.Itoa(pulls[i].Number),
+	sorted := []sortedVersion{}
+	for _, pull := range pulls {
+		if trigger != versionTriggerUpdate {
+			if err = cc.setPushedAt(pull, trigger); err != nil {
+				return versions, err
+			}
 		}
-		versions = append([]Version{version}, versions...)
 
-		if request.Version.Ref == pulls[i].Ref {
-			break
+		if pattern != nil {
+			if err = cc.setTriggerComment(pull, pattern, request.Source.CommentTriggerTrustedOnly); err != nil {
+				return versions, err
+			}
+		}
+
+		version := newVersion(pull, trigger)
+		key, _ := version.sortKey()
+		sorted = append(sorted, sortedVersion{version, key, pull})
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[j].version.newerThan(sorted[i].version, sorted[j].key, sorted[i].key)
+	})
+
+	if request.Version.Ref == "" {
+		for _, s := range sorted {
+			versions = append(versions, s.version)
+		}
+		return versions, nil
+	}
+
+	given := request.Version
+	givenKey, ok := given.sortKey()
+	if !ok {
+		// Versions without a sort key are placed by their pull, as long as it
+		// is still at the same head commit.
+		for _, s := range sorted {
+			if given.identifies(s.pull) {
+				givenKey, ok = s.key, true
+				given.PR = s.version.PR
+				break
+			}
+		}
+	}
+
+	if !ok {
+		return []Version{sorted[len(sorted)-1].version}, nil
+	}
+
+	late, newer := []Version{}, []Version{}
+	for _, s := range sorted {
+		switch {
+		case given.matches(s.pull, trigger):
+			versions = append(versions, s.version)
+		case s.version.newerThan(given, s.key, givenKey):
+			newer = append(newer, s.version)
+		case !given.identifies(s.pull) && s.pull.UpdatedAt.After(givenKey):
+			late = append(late, s.version)
 		}
 	}
-	return versions, nil
+	versions = append(versions, late...)
+	return append(versions, newer...), nil
+}
+
+// setPushedAt sets the time the head of pull was pushed, as far as GitHub
+// tells: the latest of the time its head was committed, the time the pull
+// was opened and the time it was last force pushed. Commit times are up to
+// whoever committed, the others make pulls opened or force pushed with older
+// commits still sort after the versions emitted before them. The time the
+// base was committed counts too when base changes trigger versions. Merged
+// pulls sort by the time they landed on the base instead.
+func (cc *CheckCommand) setPushedAt(pull *Pull, trigger string) error {
+	if pull.LatestCommitSHA == "" || !pull.PushedAt.IsZero() {
+		return nil
+	}
+
+	if pull.MergeCommitSHA != "" {
+		merge, err := cc.github.GetCommit(pull.MergeCommitSHA)
+		if err != nil {
+			return err
+		}
+		pull.PushedAt = merge.CommittedAt
+		return nil
+	}
+
+	head, err := cc.github.GetCommit(pull.LatestCommitSHA)
+	if err != nil {
+		return err
+	}
+
+	forcePushedAt, err := cc.github.LastForcePush(pull.Number)
+	if err != nil {
+		return err
+	}
+
+	pull.PushedAt = latest(head.CommittedAt, pull.CreatedAt, forcePushedAt)
+
+	if trigger != versionTriggerBase || pull.BaseSHA == "" {
+		return nil
+	}
+
+	base, err := cc.github.GetCommit(pull.BaseSHA)
+	if err != nil {
+		return err
+	}
+	pull.PushedAt = latest(pull.PushedAt, base.CommittedAt)
+	return nil
+}
+
+func latest(times ...time.Time) time.Time {
+	var t time.Time
+	for _, other := range times {
+		if other.After(t) {
+			t = other
+		}
+	}
+	return t
+}
+
+// setTriggerComment sets the latest comment asking to build the head of pull.
+func (cc *CheckCommand) setTriggerComment(pull *Pull, pattern *regexp.Regexp, trustedOnly bool) error {
+	comments, err := cc.github.ListComments(pull.Number)
+	if err != nil {
+		return err
+	}
+
+	pull.TriggerComment = triggerComment(comments, pattern, trustedOnly, pull.PushedAt)
+	return nil
 }
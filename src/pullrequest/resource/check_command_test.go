@@ -1,7 +1,13 @@
 package resource_test
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
 	r "pullrequest/resource"
@@ -89,5 +95,705 @@ var _ = Describe("CheckCommand", func() {
 				Expect(versions[1].Ref).To(Equal("fake-ref3"))
 			})
 		})
+
+		Context("when pulls are not listed in push order", func() {
+			It("should return versions ordered by push time", func() {
+				now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+				fakeGithub := &fake.FGithub{
+					ListPRResult: []*r.Pull{
+						&r.Pull{Number: 1, Ref: "fake-ref1", LatestCommitSHA: "fake-ref1", UpdatedAt: now, PushedAt: now.Add(-1 * time.Hour)},
+						&r.Pull{Number: 3, Ref: "fake-ref3", LatestCommitSHA: "fake-ref3", UpdatedAt: now, PushedAt: now.Add(-3 * time.Hour)},
+						&r.Pull{Number: 2, Ref: "fake-ref2", LatestCommitSHA: "fake-ref2", UpdatedAt: now, PushedAt: now.Add(-2 * time.Hour)},
+					},
+				}
+				checkCommand := r.NewCheckCommand(fakeGithub)
+				checkRequest := r.CheckRequest{
+					Source:  r.Source{},
+					Version: r.Version{Ref: "fake-ref3", PR: "3", PushedAt: "2018-06-01T09:00:00Z"},
+				}
+
+				versions, err := checkCommand.Run(checkRequest)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versions).To(Equal([]r.Version{
+					{Ref: "fake-ref3", PR: "3", PushedAt: "2018-06-01T09:00:00Z"},
+					{Ref: "fake-ref2", PR: "2", PushedAt: "2018-06-01T10:00:00Z"},
+					{Ref: "fake-ref1", PR: "1", PushedAt: "2018-06-01T11:00:00Z"},
+				}))
+			})
+		})
+
+		Context("when source has filters", func() {
+			var fakeGithub *fake.FGithub
+
+			BeforeEach(func() {
+				fakeGithub = &fake.FGithub{
+					ListPRResult: []*r.Pull{
+						&r.Pull{Number: 1, Ref: "fake-ref1", BaseRef: "master", Labels: []string{"ready"}},
+						&r.Pull{Number: 2, Ref: "fake-ref2", BaseRef: "develop", Labels: []string{"ready", "wip"}},
+						&r.Pull{Number: 3, Ref: "fake-ref3", BaseRef: "master", Draft: true},
+					},
+					ListChangedFilesResult: map[int][]string{
+						1: []string{"docs/README.md", "docs/img/logo.png"},
+						2: []string{"src/main.go", "docs/README.md"},
+						3: []string{"src/lib/util.go"},
+					},
+				}
+			})
+
+			run := func(source r.Source) []r.Version {
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: source})
+				Expect(err).ToNot(HaveOccurred())
+				return versions
+			}
+
+			It("should filter by base branch", func() {
+				Expect(run(r.Source{BaseBranch: "develop"})).To(Equal([]r.Version{{Ref: "fake-ref2", PR: "2"}}))
+			})
+
+			It("should filter by required labels", func() {
+				Expect(run(r.Source{RequiredLabels: []string{"ready", "wip"}})).To(Equal([]r.Version{{Ref: "fake-ref2", PR: "2"}}))
+			})
+
+			It("should filter by ignored labels", func() {
+				versions := run(r.Source{IgnoreLabels: []string{"wip"}})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref1", PR: "1"}, {Ref: "fake-ref3", PR: "3"}}))
+			})
+
+			It("should filter drafts", func() {
+				versions := run(r.Source{IgnoreDrafts: true})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref1", PR: "1"}, {Ref: "fake-ref2", PR: "2"}}))
+			})
+
+			It("should keep pulls changing a file below paths", func() {
+				versions := run(r.Source{Paths: []string{"src"}})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref2", PR: "2"}, {Ref: "fake-ref3", PR: "3"}}))
+			})
+
+			It("should match paths as globs", func() {
+				Expect(run(r.Source{Paths: []string{"src/*/*.go"}})).To(Equal([]r.Version{{Ref: "fake-ref3", PR: "3"}}))
+			})
+
+			It("should drop pulls only changing ignore_paths", func() {
+				versions := run(r.Source{IgnorePaths: []string{"docs/"}})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref2", PR: "2"}, {Ref: "fake-ref3", PR: "3"}}))
+			})
+
+			It("should not list changed files without path filters", func() {
+				fakeGithub.ListChangedFilesError = errors.New("fake-files-error")
+				Expect(run(r.Source{})).To(HaveLen(3))
+			})
+
+			It("should return error when listing changed files fails", func() {
+				fakeGithub.ListChangedFilesError = errors.New("fake-files-error")
+				_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{Paths: []string{"src"}}})
+				Expect(err).To(MatchError("fake-files-error"))
+			})
+		})
+
+		Context("when source trusts authors", func() {
+			var fakeGithub *fake.FGithub
+
+			BeforeEach(func() {
+				fakeGithub = &fake.FGithub{
+					ListPRResult: []*r.Pull{
+						&r.Pull{Number: 1, Ref: "fake-ref1", Author: "alice"},
+						&r.Pull{Number: 2, Ref: "fake-ref2", Author: "bob"},
+						&r.Pull{Number: 3, Ref: "fake-ref3", Author: "carol"},
+						&r.Pull{Number: 4, Ref: "fake-ref4", Author: "carol"},
+					},
+					TeamMembers: map[string][]string{"fake-org/core": []string{"bob"}},
+					Permissions: map[string]string{"alice": "read", "bob": "write", "carol": "admin"},
+				}
+			})
+
+			run := func(source r.Source) []string {
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: source})
+				Expect(err).ToNot(HaveOccurred())
+				refs := []string{}
+				for _, version := range versions {
+					refs = append(refs, version.Ref)
+				}
+				return refs
+			}
+
+			It("should trust everybody by default", func() {
+				Expect(run(r.Source{})).To(HaveLen(4))
+				Expect(fakeGithub.IsTeamMemberCalls).To(BeZero())
+				Expect(fakeGithub.GetPermissionCalls).To(BeZero())
+			})
+
+			It("should keep pulls of trusted_authors", func() {
+				Expect(run(r.Source{TrustedAuthors: []string{"Alice"}})).To(Equal([]string{"fake-ref1"}))
+			})
+
+			It("should keep pulls of members of trusted_teams", func() {
+				Expect(run(r.Source{TrustedTeams: []string{"fake-org/core"}})).To(Equal([]string{"fake-ref2"}))
+			})
+
+			It("should keep pulls of collaborators with min_permission", func() {
+				Expect(run(r.Source{MinPermission: "write"})).To(Equal([]string{"fake-ref2", "fake-ref3", "fake-ref4"}))
+				Expect(run(r.Source{MinPermission: "admin"})).To(Equal([]string{"fake-ref3", "fake-ref4"}))
+			})
+
+			It("should combine the allowlists", func() {
+				source := r.Source{TrustedAuthors: []string{"alice"}, TrustedTeams: []string{"fake-org/core"}, MinPermission: "admin"}
+				Expect(run(source)).To(Equal([]string{"fake-ref1", "fake-ref2", "fake-ref3", "fake-ref4"}))
+			})
+
+			It("should return error for an invalid team", func() {
+				_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{TrustedTeams: []string{"core"}}})
+				Expect(err).To(MatchError("core is not a valid team, expected org/team"))
+			})
+
+			It("should return error for an invalid min_permission", func() {
+				_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{MinPermission: "none"}})
+				Expect(err).To(MatchError("none is not a valid min_permission"))
+			})
+
+			It("should return error when a lookup fails", func() {
+				fakeGithub.GetPermissionError = errors.New("fake-permission-error")
+				_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{MinPermission: "write"}})
+				Expect(err).To(MatchError("fake-permission-error"))
+			})
+		})
+
+		Context("when pulls ask to skip ci", func() {
+			var fakeGithub *fake.FGithub
+
+			BeforeEach(func() {
+				fakeGithub = &fake.FGithub{
+					ListPRResult: []*r.Pull{
+						&r.Pull{Number: 1, Ref: "fake-ref1", LatestCommitSHA: "fake-ref1"},
+						&r.Pull{Number: 2, Ref: "fake-ref2", LatestCommitSHA: "fake-ref2", Title: "Fix typo [skip ci]"},
+						&r.Pull{Number: 3, Ref: "fake-ref3", LatestCommitSHA: "fake-ref3", Body: "docs only, [no build]"},
+						&r.Pull{Number: 4, Ref: "fake-ref4", LatestCommitSHA: "fake-ref4"},
+					},
+					GetCommitResult: map[string]*r.Commit{
+						"fake-ref1": {SHA: "fake-ref1", Message: "Update docs\n\n[ci skip]"},
+						"fake-ref4": {SHA: "fake-ref4", Message: "Fix build [no build]"},
+					},
+				}
+			})
+
+			run := func(source r.Source) []r.Version {
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: source})
+				Expect(err).ToNot(HaveOccurred())
+				return versions
+			}
+
+			It("should skip pulls whose head commit has a default marker", func() {
+				versions := run(r.Source{})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref2", PR: "2"}, {Ref: "fake-ref3", PR: "3"}, {Ref: "fake-ref4", PR: "4"}}))
+			})
+
+			It("should check the title and body with skip_markers_in_pr", func() {
+				versions := run(r.Source{SkipMarkersInPR: true})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref3", PR: "3"}, {Ref: "fake-ref4", PR: "4"}}))
+			})
+
+			It("should use the configured skip_markers", func() {
+				versions := run(r.Source{SkipMarkers: []string{"[no build]"}, SkipMarkersInPR: true})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref1", PR: "1"}, {Ref: "fake-ref2", PR: "2"}}))
+			})
+
+			It("should not skip anything with empty skip_markers", func() {
+				versions := run(r.Source{SkipMarkers: []string{}, SkipMarkersInPR: true})
+				Expect(versions).To(HaveLen(4))
+			})
+
+			It("should return error when getting the head commit fails", func() {
+				fakeGithub.GetCommitError = errors.New("fake-commit-error")
+				_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{}})
+				Expect(err).To(MatchError("fake-commit-error"))
+			})
+		})
+
+		Context("when source has a comment_trigger", func() {
+			var fakeGithub *fake.FGithub
+
+			at := func(hour int) time.Time {
+				return time.Date(2018, 6, 1, hour, 0, 0, 0, time.UTC)
+			}
+
+			BeforeEach(func() {
+				fakeGithub = &fake.FGithub{
+					ListPRResult: []*r.Pull{
+						&r.Pull{Number: 1, Ref: "fake-ref1", LatestCommitSHA: "fake-ref1", PushedAt: at(10)},
+						&r.Pull{Number: 2, Ref: "fake-ref2", LatestCommitSHA: "fake-ref2", PushedAt: at(12)},
+					},
+					ListCommentsResult: []*r.Comment{
+						{ID: 5, Body: "/retest integration", Author: "alice", AuthorAssociation: "MEMBER", CreatedAt: at(11)},
+						{ID: 6, Body: "lgtm", Author: "alice", AuthorAssociation: "MEMBER", CreatedAt: at(13)},
+						{ID: 7, Body: "/retest", Author: "outsider", AuthorAssociation: "NONE", CreatedAt: at(14)},
+					},
+				}
+			})
+
+			It("should emit a version for the latest matching comment after the head commit", func() {
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{
+					Source: r.Source{CommentTrigger: "^/retest"},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versions).To(Equal([]r.Version{
+					{Ref: "fake-ref1", PR: "1", PushedAt: "2018-06-01T10:00:00Z", CommentID: "7", CommentedAt: "2018-06-01T14:00:00Z"},
+					{Ref: "fake-ref2", PR: "2", PushedAt: "2018-06-01T12:00:00Z", CommentID: "7", CommentedAt: "2018-06-01T14:00:00Z"},
+				}))
+			})
+
+			It("should ignore comments of untrusted users when restricted", func() {
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{
+					Source: r.Source{CommentTrigger: "^/retest", CommentTriggerTrustedOnly: true},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versions).To(Equal([]r.Version{
+					{Ref: "fake-ref1", PR: "1", PushedAt: "2018-06-01T10:00:00Z", CommentID: "5", CommentedAt: "2018-06-01T11:00:00Z"},
+					{Ref: "fake-ref2", PR: "2", PushedAt: "2018-06-01T12:00:00Z"},
+				}))
+			})
+
+			It("should emit a new version when a comment follows the last build", func() {
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{
+					Source:  r.Source{CommentTrigger: "^/retest", CommentTriggerTrustedOnly: true},
+					Version: r.Version{Ref: "fake-ref2", PR: "2", PushedAt: "2018-06-01T12:00:00Z"},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versions).To(Equal([]r.Version{
+					{Ref: "fake-ref2", PR: "2", PushedAt: "2018-06-01T12:00:00Z"},
+				}))
+
+				fakeGithub.ListCommentsResult = append(fakeGithub.ListCommentsResult,
+					&r.Comment{ID: 8, Body: "/retest", Author: "bob", AuthorAssociation: "COLLABORATOR", CreatedAt: at(15)})
+
+				versions, err = r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{
+					Source:  r.Source{CommentTrigger: "^/retest", CommentTriggerTrustedOnly: true},
+					Version: r.Version{Ref: "fake-ref2", PR: "2", PushedAt: "2018-06-01T12:00:00Z"},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versions).To(Equal([]r.Version{
+					{Ref: "fake-ref1", PR: "1", PushedAt: "2018-06-01T10:00:00Z", CommentID: "8", CommentedAt: "2018-06-01T15:00:00Z"},
+					{Ref: "fake-ref2", PR: "2", PushedAt: "2018-06-01T12:00:00Z", CommentID: "8", CommentedAt: "2018-06-01T15:00:00Z"},
+				}))
+			})
+
+			It("should return error for an invalid comment_trigger", func() {
+				_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{CommentTrigger: "(retest"}})
+				Expect(err).To(MatchError(HavePrefix("(retest is not a valid comment_trigger")))
+			})
+
+			It("should return error when listing comments fails", func() {
+				fakeGithub.ListCommentsError = errors.New("fake-comments-error")
+				_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{CommentTrigger: "^/retest"}})
+				Expect(err).To(MatchError("fake-comments-error"))
+			})
+		})
+
+		Context("when source requires review approval", func() {
+			var fakeGithub *fake.FGithub
+
+			approve := func(author string) *r.Review {
+				return &r.Review{Author: author, AuthorAssociation: "MEMBER", State: "APPROVED"}
+			}
+
+			BeforeEach(func() {
+				fakeGithub = &fake.FGithub{
+					ListPRResult: []*r.Pull{
+						&r.Pull{Number: 1, Ref: "fake-ref1"},
+						&r.Pull{Number: 2, Ref: "fake-ref2"},
+						&r.Pull{Number: 3, Ref: "fake-ref3"},
+						&r.Pull{Number: 4, Ref: "fake-ref4"},
+					},
+					ListReviewsResult: map[int][]*r.Review{
+						2: []*r.Review{approve("alice")},
+						3: []*r.Review{
+							approve("alice"),
+							approve("bob"),
+							{Author: "bob", AuthorAssociation: "MEMBER", State: "COMMENTED"},
+							{Author: "outsider", AuthorAssociation: "NONE", State: "APPROVED"},
+						},
+						4: []*r.Review{
+							approve("alice"),
+							approve("bob"),
+							{Author: "carol", AuthorAssociation: "OWNER", State: "CHANGES_REQUESTED"},
+						},
+					},
+				}
+			})
+
+			run := func(source r.Source) []r.Version {
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: source})
+				Expect(err).ToNot(HaveOccurred())
+				return versions
+			}
+
+			It("should keep approved pulls without pending change requests", func() {
+				versions := run(r.Source{RequireReviewApproval: true})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref2", PR: "2"}, {Ref: "fake-ref3", PR: "3"}}))
+			})
+
+			It("should count approvals of reviewers with write access against min_approvals", func() {
+				versions := run(r.Source{RequireReviewApproval: true, MinApprovals: 2})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref3", PR: "3"}}))
+			})
+
+			It("should go by the latest verdict of every reviewer", func() {
+				fakeGithub.ListReviewsResult[4] = append(fakeGithub.ListReviewsResult[4], approve("carol"))
+				versions := run(r.Source{RequireReviewApproval: true, MinApprovals: 3})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref4", PR: "4"}}))
+			})
+
+			It("should return error when listing reviews fails", func() {
+				fakeGithub.ListReviewsError = errors.New("fake-reviews-error")
+				_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{RequireReviewApproval: true}})
+				Expect(err).To(MatchError("fake-reviews-error"))
+			})
+		})
+
+		Context("when source has a fork_policy", func() {
+			var fakeGithub *fake.FGithub
+
+			BeforeEach(func() {
+				fakeGithub = &fake.FGithub{
+					ListPRResult: []*r.Pull{
+						&r.Pull{Number: 1, Ref: "fake-ref1", LatestCommitSHA: "fake-sha1"},
+						&r.Pull{Number: 2, Ref: "fake-ref2", LatestCommitSHA: "fake-sha2", Fork: true, Labels: []string{"ok-to-test"}},
+						&r.Pull{Number: 3, Ref: "fake-ref3", LatestCommitSHA: "fake-sha3", Fork: true},
+					},
+					ListReviewsResult: map[int][]*r.Review{
+						2: []*r.Review{
+							{Author: "maintainer", AuthorAssociation: "MEMBER", State: "APPROVED", CommitID: "fake-old-sha"},
+						},
+						3: []*r.Review{
+							{Author: "outsider", AuthorAssociation: "NONE", State: "APPROVED", CommitID: "fake-sha3"},
+							{Author: "maintainer", AuthorAssociation: "COLLABORATOR", State: "APPROVED", CommitID: "fake-sha3"},
+						},
+					},
+				}
+			})
+
+			run := func(source r.Source) []r.Version {
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: source})
+				Expect(err).ToNot(HaveOccurred())
+				return versions
+			}
+
+			It("should allow forks by default", func() {
+				Expect(run(r.Source{})).To(HaveLen(3))
+			})
+
+			It("should skip forks with disallow", func() {
+				Expect(run(r.Source{ForkPolicy: "disallow"})).To(Equal([]r.Version{{Ref: "fake-ref1", PR: "1"}}))
+			})
+
+			It("should keep labelled forks with require_label", func() {
+				versions := run(r.Source{ForkPolicy: "require_label"})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref1", PR: "1"}, {Ref: "fake-ref2", PR: "2"}}))
+			})
+
+			It("should skip forks pushed to after they were labelled with require_label", func() {
+				fakeGithub.StaleLabels = map[int]bool{2: true}
+				versions := run(r.Source{ForkPolicy: "require_label"})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref1", PR: "1"}}))
+			})
+
+			It("should use the configured fork_label", func() {
+				fakeGithub.ListPRResult[2].Labels = []string{"safe"}
+				versions := run(r.Source{ForkPolicy: "require_label", ForkLabel: "safe"})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref1", PR: "1"}, {Ref: "fake-ref3", PR: "3"}}))
+			})
+
+			It("should keep forks whose head was approved by a collaborator with require_approved_review", func() {
+				versions := run(r.Source{ForkPolicy: "require_approved_review"})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref1", PR: "1"}, {Ref: "fake-ref3", PR: "3"}}))
+			})
+
+			It("should drop forks whose approval was withdrawn", func() {
+				fakeGithub.ListReviewsResult[3] = append(fakeGithub.ListReviewsResult[3],
+					&r.Review{Author: "maintainer", AuthorAssociation: "COLLABORATOR", State: "CHANGES_REQUESTED", CommitID: "fake-sha3"})
+				versions := run(r.Source{ForkPolicy: "require_approved_review"})
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-ref1", PR: "1"}}))
+			})
+
+			It("should return error when listing reviews fails", func() {
+				fakeGithub.ListReviewsError = errors.New("fake-reviews-error")
+				_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{ForkPolicy: "require_approved_review"}})
+				Expect(err).To(MatchError("fake-reviews-error"))
+			})
+
+			It("should return error for an unknown policy", func() {
+				_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{ForkPolicy: "fake-policy"}})
+				Expect(err).To(MatchError("fake-policy is not a valid fork_policy"))
+			})
+		})
+
+		Context("when source has a version_trigger", func() {
+			var fakeGithub *fake.FGithub
+			var updatedAt time.Time
+
+			BeforeEach(func() {
+				updatedAt = time.Date(2018, 6, 1, 12, 30, 0, 0, time.UTC)
+				fakeGithub = &fake.FGithub{
+					ListPRResult: []*r.Pull{
+						&r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1", BaseSHA: "fake-base1", UpdatedAt: updatedAt},
+					},
+				}
+			})
+
+			It("should key versions on the head commit by default", func() {
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-sha1", PR: "1"}}))
+			})
+
+			It("should add the base commit for base", func() {
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{VersionTrigger: "base"}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-sha1", PR: "1", BaseSHA: "fake-base1"}}))
+			})
+
+			It("should add the update time for update", func() {
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{VersionTrigger: "update"}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versions).To(Equal([]r.Version{{Ref: "fake-sha1", PR: "1", UpdatedAt: "2018-06-01T12:30:00Z"}}))
+			})
+
+			It("should return error for an unknown trigger", func() {
+				_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{VersionTrigger: "fake-trigger"}})
+				Expect(err).To(MatchError("fake-trigger is not a valid version_trigger"))
+			})
+		})
+
+		Context("when given version has a legacy ref", func() {
+			It("should match it by its head sha prefix", func() {
+				fakeGithub := &fake.FGithub{
+					ListPRResult: []*r.Pull{
+						&r.Pull{Number: 1, Ref: "abcdef0123", LatestCommitSHA: "abcdef0123", UpdatedAt: time.Unix(1, 0)},
+						&r.Pull{Number: 2, Ref: "1234567abc", LatestCommitSHA: "1234567abc", UpdatedAt: time.Unix(2, 0)},
+					},
+				}
+
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{
+					Version: r.Version{Ref: "1234567-2018-06-01T12:30:00Z", PR: "2"},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versions).To(Equal([]r.Version{{Ref: "1234567abc", PR: "2"}}))
+			})
+		})
+	})
+
+	Context("when ordering versions", func() {
+		at := func(hour int) time.Time {
+			return time.Date(2018, 6, 1, hour, 0, 0, 0, time.UTC)
+		}
+
+		// pull is at head <number>-<head> committed at the given hour.
+		pull := func(number int, head string, hour int) *r.Pull {
+			sha := fmt.Sprintf("sha%d-%s", number, head)
+			return &r.Pull{Number: number, Ref: sha, LatestCommitSHA: sha, BaseSHA: "base", UpdatedAt: at(hour), PushedAt: at(hour)}
+		}
+
+		// Shared by the entries below, which must use pulls of their own.
+		commits := map[string]*r.Commit{}
+		forcePushes := map[int]time.Time{}
+
+		// opened is like pull, but its head was committed at the given hour
+		// before the pull was opened.
+		opened := func(number int, head string, committedHour, openedHour int) *r.Pull {
+			p := pull(number, head, openedHour)
+			p.PushedAt, p.CreatedAt = time.Time{}, at(openedHour)
+			commits[p.LatestCommitSHA] = &r.Commit{SHA: p.LatestCommitSHA, CommittedAt: at(committedHour)}
+			return p
+		}
+
+		// forcePushed is like pull, but its head was committed at the given
+		// hour before it was force pushed.
+		forcePushed := func(number int, head string, committedHour, pushedHour int) *r.Pull {
+			p := pull(number, head, pushedHour)
+			p.PushedAt = time.Time{}
+			commits[p.LatestCommitSHA] = &r.Commit{SHA: p.LatestCommitSHA, CommittedAt: at(committedHour)}
+			forcePushes[number] = at(pushedHour)
+			return p
+		}
+
+		// pushed is like pull, but its head was committed at the given hour
+		// before it was pushed.
+		pushed := func(number int, head string, committedHour, pushedHour int) *r.Pull {
+			p := pull(number, head, committedHour)
+			p.UpdatedAt = at(pushedHour)
+			return p
+		}
+
+		version := func(number int, head string, hour int) r.Version {
+			return r.Version{Ref: fmt.Sprintf("sha%d-%s", number, head), PR: strconv.Itoa(number), PushedAt: at(hour).Format(time.RFC3339)}
+		}
+
+		DescribeTable("check",
+			func(pulls []*r.Pull, source r.Source, given r.Version, expected []r.Version) {
+				fakeGithub := &fake.FGithub{ListPRResult: pulls, GetCommitResult: commits, ForcePushes: forcePushes}
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: source, Version: given})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versions).To(Equal(expected))
+			},
+			Entry("without a version returns every pull by commit time",
+				[]*r.Pull{pull(1, "a", 3), pull(2, "a", 1), pull(3, "a", 2)},
+				r.Source{},
+				r.Version{},
+				[]r.Version{version(2, "a", 1), version(3, "a", 2), version(1, "a", 3)},
+			),
+			Entry("with the latest version returns only it",
+				[]*r.Pull{pull(1, "a", 1), pull(2, "a", 2)},
+				r.Source{},
+				version(2, "a", 2),
+				[]r.Version{version(2, "a", 2)},
+			),
+			Entry("with an older version returns it and newer ones",
+				[]*r.Pull{pull(1, "a", 1), pull(2, "a", 2), pull(3, "a", 3)},
+				r.Source{},
+				version(2, "a", 2),
+				[]r.Version{version(2, "a", 2), version(3, "a", 3)},
+			),
+			Entry("when the pull of the version was closed returns only newer ones",
+				[]*r.Pull{pull(1, "a", 1), pull(3, "a", 3)},
+				r.Source{},
+				version(2, "a", 2),
+				[]r.Version{version(3, "a", 3)},
+			),
+			Entry("when the pull of the version was closed and nothing is newer returns nothing",
+				[]*r.Pull{pull(1, "a", 1)},
+				r.Source{},
+				version(2, "a", 2),
+				[]r.Version{},
+			),
+			Entry("when the pull of the version was force pushed returns its new head",
+				[]*r.Pull{pull(1, "a", 1), pull(2, "b", 4), pull(3, "a", 3)},
+				r.Source{},
+				version(2, "a", 2),
+				[]r.Version{version(3, "a", 3), version(2, "b", 4)},
+			),
+			Entry("when a pull is opened with a commit older than the version returns it",
+				[]*r.Pull{pull(5, "a", 12), opened(6, "a", 9, 13)},
+				r.Source{},
+				version(5, "a", 12),
+				[]r.Version{version(5, "a", 12), version(6, "a", 13)},
+			),
+			Entry("when a pull is force pushed with a commit older than the version returns it",
+				[]*r.Pull{pull(5, "a", 12), forcePushed(4, "b", 9, 13)},
+				r.Source{},
+				version(5, "a", 12),
+				[]r.Version{version(5, "a", 12), version(4, "b", 13)},
+			),
+			Entry("when a pull is pushed to with a commit older than the version returns it after the version",
+				[]*r.Pull{pull(5, "a", 12), pushed(6, "b", 9, 13), pull(7, "a", 14)},
+				r.Source{},
+				version(5, "a", 12),
+				[]r.Version{version(5, "a", 12), version(6, "b", 9), version(7, "a", 14)},
+			),
+			Entry("when the version was pushed to with an older commit returns its new head",
+				[]*r.Pull{pushed(5, "b", 9, 13)},
+				r.Source{},
+				version(5, "a", 12),
+				[]r.Version{version(5, "b", 9)},
+			),
+			Entry("when a pull is opened with a commit newer than it sorts it by commit time",
+				[]*r.Pull{pull(5, "a", 12), opened(7, "a", 14, 13)},
+				r.Source{},
+				version(5, "a", 12),
+				[]r.Version{version(5, "a", 12), version(7, "a", 14)},
+			),
+			Entry("when pulls are listed out of order sorts them by commit time",
+				[]*r.Pull{pull(4, "a", 4), pull(1, "a", 1), pull(3, "a", 3), pull(2, "a", 2)},
+				r.Source{},
+				version(2, "a", 2),
+				[]r.Version{version(2, "a", 2), version(3, "a", 3), version(4, "a", 4)},
+			),
+			Entry("when pulls were committed at the same time sorts them by number",
+				[]*r.Pull{pull(3, "a", 2), pull(2, "a", 2), pull(1, "a", 1)},
+				r.Source{},
+				version(2, "a", 2),
+				[]r.Version{version(2, "a", 2), version(3, "a", 2)},
+			),
+			Entry("when the version has no sort key places it by its pull",
+				[]*r.Pull{pull(1, "a", 1), pull(2, "a", 2), pull(3, "a", 3)},
+				r.Source{},
+				r.Version{Ref: "sha2-a", PR: "2"},
+				[]r.Version{version(2, "a", 2), version(3, "a", 3)},
+			),
+			Entry("when the version has no sort key and its pull moved returns the latest",
+				[]*r.Pull{pull(1, "a", 1), pull(2, "b", 2), pull(3, "a", 3)},
+				r.Source{},
+				r.Version{Ref: "sha2-a", PR: "2"},
+				[]r.Version{version(3, "a", 3)},
+			),
+			Entry("when the version has a legacy ref sorts it by its update time",
+				[]*r.Pull{pull(1, "a", 1), pull(2, "a", 2), pull(3, "a", 3)},
+				r.Source{},
+				r.Version{Ref: "abcdef0-2018-06-01T02:30:00Z"},
+				[]r.Version{version(3, "a", 3)},
+			),
+			Entry("with version_trigger update returns pulls updated since",
+				[]*r.Pull{pull(1, "a", 1), pull(2, "a", 2)},
+				r.Source{VersionTrigger: "update"},
+				r.Version{Ref: "sha1-a", PR: "1", UpdatedAt: "2018-06-01T00:00:00Z"},
+				[]r.Version{
+					{Ref: "sha1-a", PR: "1", UpdatedAt: "2018-06-01T01:00:00Z"},
+					{Ref: "sha2-a", PR: "2", UpdatedAt: "2018-06-01T02:00:00Z"},
+				},
+			),
+		)
+
+		It("should sort by the base commit when it moved later with version_trigger base", func() {
+			fakeGithub := &fake.FGithub{
+				ListPRResult: []*r.Pull{
+					&r.Pull{Number: 1, Ref: "sha1", LatestCommitSHA: "sha1", BaseSHA: "base2"},
+					&r.Pull{Number: 2, Ref: "sha2", LatestCommitSHA: "sha2", BaseSHA: "base2"},
+				},
+				GetCommitResult: map[string]*r.Commit{
+					"sha1":  &r.Commit{SHA: "sha1", CommittedAt: at(1)},
+					"sha2":  &r.Commit{SHA: "sha2", CommittedAt: at(3)},
+					"base2": &r.Commit{SHA: "base2", CommittedAt: at(2)},
+				},
+			}
+
+			versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{
+				Source:  r.Source{VersionTrigger: "base"},
+				Version: r.Version{Ref: "sha1", PR: "1", BaseSHA: "base1", PushedAt: at(1).Format(time.RFC3339)},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versions).To(Equal([]r.Version{
+				{Ref: "sha1", PR: "1", BaseSHA: "base2", PushedAt: at(2).Format(time.RFC3339)},
+				{Ref: "sha2", PR: "2", BaseSHA: "base2", PushedAt: at(3).Format(time.RFC3339)},
+			}))
+		})
+
+		It("should sort merged pulls by the time they were merged", func() {
+			fakeGithub := &fake.FGithub{
+				ListPRResult: []*r.Pull{
+					&r.Pull{Number: 1, Ref: "sha1", LatestCommitSHA: "sha1", State: "merged", MergeCommitSHA: "merge1"},
+					&r.Pull{Number: 2, Ref: "sha2", LatestCommitSHA: "sha2", State: "merged", MergeCommitSHA: "merge2"},
+				},
+				GetCommitResult: map[string]*r.Commit{
+					"sha1":   &r.Commit{SHA: "sha1", CommittedAt: at(1)},
+					"sha2":   &r.Commit{SHA: "sha2", CommittedAt: at(2)},
+					"merge1": &r.Commit{SHA: "merge1", CommittedAt: at(4)},
+					"merge2": &r.Commit{SHA: "merge2", CommittedAt: at(3)},
+				},
+			}
+
+			versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: r.Source{States: []string{"merged"}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versions).To(Equal([]r.Version{
+				{Ref: "sha2", PR: "2", PushedAt: at(3).Format(time.RFC3339)},
+				{Ref: "sha1", PR: "1", PushedAt: at(4).Format(time.RFC3339)},
+			}))
+		})
+
+		It("should return error when getting a commit fails", func() {
+			fakeGithub := &fake.FGithub{
+				ListPRResult:   []*r.Pull{&r.Pull{Number: 1, Ref: "sha1", LatestCommitSHA: "sha1"}},
+				GetCommitError: errors.New("fake-commit-error"),
+			}
+
+			_, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{})
+			Expect(err).To(MatchError("fake-commit-error"))
+		})
 	})
 })
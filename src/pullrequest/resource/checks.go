@@ -0,0 +1,204 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+)
+
+// mediaTypeChecksPreview is needed for the checks API, which the vendored
+// client does not cover yet.
+const mediaTypeChecksPreview = "application/vnd.github.antiope-preview+json"
+
+// GitHub accepts at most this many annotations per request.
+const maxAnnotationsPerRequest = 50
+
+// CheckRun is
+type CheckRun struct {
+	Name        string
+	Status      string
+	Conclusion  string
+	Title       string
+	Summary     string
+	Text        string
+	DetailsURL  string
+	Annotations []CheckRunAnnotation
+}
+
+// CheckRunAnnotation is
+type CheckRunAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Message         string `json:"message"`
+	Title           string `json:"title,omitempty"`
+	RawDetails      string `json:"raw_details,omitempty"`
+}
+
+type checkRunOutput struct {
+	Title       string               `json:"title"`
+	Summary     string               `json:"summary"`
+	Text        string               `json:"text,omitempty"`
+	Annotations []CheckRunAnnotation `json:"annotations,omitempty"`
+}
+
+type checkRunRequest struct {
+	Name       string          `json:"name,omitempty"`
+	HeadSHA    string          `json:"head_sha,omitempty"`
+	Status     string          `json:"status,omitempty"`
+	Conclusion string          `json:"conclusion,omitempty"`
+	DetailsURL string          `json:"details_url,omitempty"`
+	Output     *checkRunOutput `json:"output,omitempty"`
+}
+
+type checkRunResponse struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	HTMLURL string `json:"html_url"`
+}
+
+type checkRunList struct {
+	TotalCount int                 `json:"total_count"`
+	CheckRuns  []*checkRunResponse `json:"check_runs"`
+}
+
+// UpdateCheckRun is
+func (gc *GithubClient) UpdateCheckRun(sourceDir string, checkRun CheckRun, repoPath string) (string, error) {
+	switch checkRun.Status {
+	case
+		"queued",
+		"in_progress",
+		"completed":
+		break
+	default:
+		return "", fmt.Errorf("%s is not a valid check run status", checkRun.Status)
+	}
+
+	switch checkRun.Conclusion {
+	case
+		"",
+		"success",
+		"failure",
+		"neutral",
+		"cancelled",
+		"timed_out",
+		"action_required":
+		break
+	default:
+		return "", fmt.Errorf("%s is not a valid check run conclusion", checkRun.Conclusion)
+	}
+
+	if checkRun.Status == "completed" && checkRun.Conclusion == "" {
+		return "", errors.New("a completed check run needs a conclusion")
+	}
+
+	commitHashBytes, err := ioutil.ReadFile(path.Join(sourceDir, repoPath, "pr_last_commit_hash"))
+	if err != nil {
+		return "", fmt.Errorf("reading pr_last_commit_hash: %+v", err)
+	}
+	headSHA := string(commitHashBytes)
+
+	existing, err := gc.findCheckRun(headSHA, checkRun.Name)
+	if err != nil {
+		return "", err
+	}
+
+	annotations := checkRun.Annotations
+	batch := annotations
+	if len(batch) > maxAnnotationsPerRequest {
+		batch = batch[:maxAnnotationsPerRequest]
+	}
+	annotations = annotations[len(batch):]
+
+	runRequest := &checkRunRequest{
+		Name:       checkRun.Name,
+		Status:     checkRun.Status,
+		Conclusion: checkRun.Conclusion,
+		DetailsURL: checkRun.DetailsURL,
+	}
+	if checkRun.Title != "" || checkRun.Summary != "" {
+		runRequest.Output = &checkRunOutput{
+			Title:       checkRun.Title,
+			Summary:     checkRun.Summary,
+			Text:        checkRun.Text,
+			Annotations: batch,
+		}
+	}
+
+	var run *checkRunResponse
+	if existing == nil {
+		runRequest.HeadSHA = headSHA
+		run, err = gc.sendCheckRun("POST", fmt.Sprintf("repos/%s/%s/check-runs", gc.owner, gc.repo), runRequest)
+	} else {
+		run, err = gc.sendCheckRun("PATCH", fmt.Sprintf("repos/%s/%s/check-runs/%d", gc.owner, gc.repo, existing.ID), runRequest)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Annotations beyond the first batch are appended by further updates,
+	// which have to repeat the title and summary of the output.
+	for len(annotations) > 0 && runRequest.Output != nil {
+		batch = annotations
+		if len(batch) > maxAnnotationsPerRequest {
+			batch = batch[:maxAnnotationsPerRequest]
+		}
+		annotations = annotations[len(batch):]
+
+		_, err = gc.sendCheckRun("PATCH", fmt.Sprintf("repos/%s/%s/check-runs/%d", gc.owner, gc.repo, run.ID), &checkRunRequest{
+			Output: &checkRunOutput{
+				Title:       checkRun.Title,
+				Summary:     checkRun.Summary,
+				Annotations: batch,
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	idBytes, err := ioutil.ReadFile(path.Join(sourceDir, repoPath, "pr_id"))
+	if err != nil {
+		return "", fmt.Errorf("reading pr_id: %+v", err)
+	}
+	return string(idBytes), nil
+}
+
+func (gc *GithubClient) findCheckRun(headSHA, name string) (*checkRunResponse, error) {
+	u := fmt.Sprintf("repos/%s/%s/commits/%s/check-runs?check_name=%s", gc.owner, gc.repo, headSHA, url.QueryEscape(name))
+	req, err := gc.client.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", mediaTypeChecksPreview)
+
+	list := new(checkRunList)
+	if _, err = gc.client.Do(context.TODO(), req, list); err != nil {
+		return nil, fmt.Errorf("listing check runs: %+v", err)
+	}
+
+	for _, run := range list.CheckRuns {
+		if run.Name == name {
+			return run, nil
+		}
+	}
+	return nil, nil
+}
+
+func (gc *GithubClient) sendCheckRun(method, u string, runRequest *checkRunRequest) (*checkRunResponse, error) {
+	req, err := gc.client.NewRequest(method, u, runRequest)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", mediaTypeChecksPreview)
+
+	run := new(checkRunResponse)
+	if _, err = gc.client.Do(context.TODO(), req, run); err != nil {
+		return nil, fmt.Errorf("updating check run: %+v", err)
+	}
+	return run, nil
+}
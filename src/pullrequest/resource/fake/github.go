@@ -1,16 +1,84 @@
 package fake
 
-import "pullrequest/resource"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pullrequest/resource"
+)
 
 // FGithub is
 type FGithub struct {
 	ListPRResult []*resource.Pull
 	ListPRError  error
 
+	GetPRResult *resource.Pull
+	GetPRError  error
+
+	ListChangedFilesResult map[int][]string
+	ListChangedFilesError  error
+
 	DownloadPRError error
+	DownloadPRFiles map[string]string
+	DownloadPRPull  *resource.Pull
+
+	GetArchiveLinkResult map[string]string
+	GetArchiveLinkError  error
 
 	UpdatePRResult string
 	UpdatePRError  error
+	UpdatePRStatus resource.CommitStatus
+
+	UpdateCheckRunResult   string
+	UpdateCheckRunError    error
+	UpdateCheckRunCheckRun resource.CheckRun
+
+	LoginResult string
+	LoginError  error
+
+	ListCommentsResult []*resource.Comment
+	ListCommentsError  error
+
+	CreateCommentBody  string
+	CreateCommentError error
+
+	EditCommentID    int64
+	EditCommentBody  string
+	EditCommentError error
+
+	AddedLabels    []string
+	AddLabelsError error
+
+	RemovedLabels    []string
+	RemoveLabelError error
+
+	MergePRResult  string
+	MergePRError   error
+	MergePRRequest *resource.MergeRequest
+
+	DeleteBranchResult string
+	DeleteBranchError  error
+
+	GetCommitResult map[string]*resource.Commit
+	GetCommitError  error
+
+	ForcePushes        map[int]time.Time
+	LastForcePushError error
+
+	StaleLabels           map[int]bool
+	LabeledSincePushError error
+
+	ListReviewsResult map[int][]*resource.Review
+	ListReviewsError  error
+
+	TeamMembers        map[string][]string
+	IsTeamMemberCalls  int
+	IsTeamMemberError  error
+	Permissions        map[string]string
+	GetPermissionCalls int
+	GetPermissionError error
 }
 
 // ListPRs is
@@ -18,12 +86,168 @@ func (fg *FGithub) ListPRs() ([]*resource.Pull, error) {
 	return fg.ListPRResult, fg.ListPRError
 }
 
+// GetPR is
+func (fg *FGithub) GetPR(prNumber int) (*resource.Pull, error) {
+	if fg.GetPRResult == nil && fg.GetPRError == nil {
+		return &resource.Pull{Number: prNumber}, nil
+	}
+	return fg.GetPRResult, fg.GetPRError
+}
+
+// ListChangedFiles is
+func (fg *FGithub) ListChangedFiles(prNumber int) ([]string, error) {
+	return fg.ListChangedFilesResult[prNumber], fg.ListChangedFilesError
+}
+
 // DownloadPR is
-func (fg *FGithub) DownloadPR(destDir string, prNumber int) error {
-	return fg.DownloadPRError
+func (fg *FGithub) DownloadPR(destDir string, pull *resource.Pull, params resource.InParams) error {
+	if fg.DownloadPRError != nil {
+		return fg.DownloadPRError
+	}
+	fg.DownloadPRPull = pull
+
+	for name, content := range fg.DownloadPRFiles {
+		file := filepath.Join(destDir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetArchiveLink is
+func (fg *FGithub) GetArchiveLink(format, ref string) (string, error) {
+	return fg.GetArchiveLinkResult[format], fg.GetArchiveLinkError
 }
 
 // UpdatePR is
-func (fg *FGithub) UpdatePR(sourceDir, status, path string) (string, error) {
+func (fg *FGithub) UpdatePR(sourceDir string, status resource.CommitStatus, path string) (string, error) {
+	fg.UpdatePRStatus = status
 	return fg.UpdatePRResult, fg.UpdatePRError
 }
+
+// UpdateCheckRun is
+func (fg *FGithub) UpdateCheckRun(sourceDir string, checkRun resource.CheckRun, path string) (string, error) {
+	fg.UpdateCheckRunCheckRun = checkRun
+	return fg.UpdateCheckRunResult, fg.UpdateCheckRunError
+}
+
+// Login is
+func (fg *FGithub) Login() (string, error) {
+	return fg.LoginResult, fg.LoginError
+}
+
+// ListComments is
+func (fg *FGithub) ListComments(prNumber int) ([]*resource.Comment, error) {
+	return fg.ListCommentsResult, fg.ListCommentsError
+}
+
+// CreateComment is
+func (fg *FGithub) CreateComment(prNumber int, body string) (*resource.Comment, error) {
+	if fg.CreateCommentError != nil {
+		return nil, fg.CreateCommentError
+	}
+	fg.CreateCommentBody = body
+	return &resource.Comment{ID: 1, Body: body, HTMLURL: "fake-comment-url"}, nil
+}
+
+// EditComment is
+func (fg *FGithub) EditComment(id int64, body string) (*resource.Comment, error) {
+	if fg.EditCommentError != nil {
+		return nil, fg.EditCommentError
+	}
+	fg.EditCommentID = id
+	fg.EditCommentBody = body
+	return &resource.Comment{ID: id, Body: body, HTMLURL: "fake-comment-url"}, nil
+}
+
+// AddLabels is
+func (fg *FGithub) AddLabels(prNumber int, labels []string) error {
+	if fg.AddLabelsError != nil {
+		return fg.AddLabelsError
+	}
+	fg.AddedLabels = append(fg.AddedLabels, labels...)
+	return nil
+}
+
+// RemoveLabel is
+func (fg *FGithub) RemoveLabel(prNumber int, label string) error {
+	if fg.RemoveLabelError != nil {
+		return fg.RemoveLabelError
+	}
+	fg.RemovedLabels = append(fg.RemovedLabels, label)
+	return nil
+}
+
+// MergePR is
+func (fg *FGithub) MergePR(prNumber int, merge resource.MergeRequest) (string, error) {
+	if fg.MergePRError != nil {
+		return "", fg.MergePRError
+	}
+	fg.MergePRRequest = &merge
+	return fg.MergePRResult, nil
+}
+
+// DeleteBranch is
+func (fg *FGithub) DeleteBranch(branch string) error {
+	if fg.DeleteBranchError != nil {
+		return fg.DeleteBranchError
+	}
+	fg.DeleteBranchResult = branch
+	return nil
+}
+
+// GetCommit is
+func (fg *FGithub) GetCommit(sha string) (*resource.Commit, error) {
+	if fg.GetCommitError != nil {
+		return nil, fg.GetCommitError
+	}
+	if commit, ok := fg.GetCommitResult[sha]; ok {
+		return commit, nil
+	}
+	return &resource.Commit{SHA: sha}, nil
+}
+
+// LastForcePush is
+func (fg *FGithub) LastForcePush(prNumber int) (time.Time, error) {
+	return fg.ForcePushes[prNumber], fg.LastForcePushError
+}
+
+// LabeledSincePush is
+func (fg *FGithub) LabeledSincePush(prNumber int, label string) (bool, error) {
+	return !fg.StaleLabels[prNumber], fg.LabeledSincePushError
+}
+
+// ListReviews is
+func (fg *FGithub) ListReviews(prNumber int) ([]*resource.Review, error) {
+	return fg.ListReviewsResult[prNumber], fg.ListReviewsError
+}
+
+// IsTeamMember looks user up in TeamMembers, which is keyed by org/team.
+func (fg *FGithub) IsTeamMember(org, team, user string) (bool, error) {
+	fg.IsTeamMemberCalls++
+	if fg.IsTeamMemberError != nil {
+		return false, fg.IsTeamMemberError
+	}
+	for _, member := range fg.TeamMembers[org+"/"+team] {
+		if member == user {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetPermission is
+func (fg *FGithub) GetPermission(user string) (string, error) {
+	fg.GetPermissionCalls++
+	if fg.GetPermissionError != nil {
+		return "", fg.GetPermissionError
+	}
+	if permission, ok := fg.Permissions[user]; ok {
+		return permission, nil
+	}
+	return "none", nil
+}
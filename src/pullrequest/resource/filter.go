@@ -0,0 +1,142 @@
+package resource
+
+import (
+	"path"
+	"strings"
+)
+
+// filterPulls returns the pulls that satisfy the filters configured in source.
+// The changed files of a pull are only fetched when a path filter needs them.
+func filterPulls(g Github, source Source, pulls []*Pull) ([]*Pull, error) {
+	filtered := []*Pull{}
+	for _, pull := range pulls {
+		if source.BaseBranch != "" && pull.BaseRef != source.BaseBranch {
+			continue
+		}
+
+		if source.IgnoreDrafts && pull.Draft {
+			continue
+		}
+
+		if !hasAllLabels(pull.Labels, source.RequiredLabels) {
+			continue
+		}
+
+		if hasAnyLabel(pull.Labels, source.IgnoreLabels) {
+			continue
+		}
+
+		if len(source.Paths) > 0 || len(source.IgnorePaths) > 0 {
+			if pull.ChangedFiles == nil {
+				files, err := g.ListChangedFiles(pull.Number)
+				if err != nil {
+					return nil, err
+				}
+				pull.ChangedFiles = files
+			}
+
+			if len(source.Paths) > 0 && !anyFileMatches(pull.ChangedFiles, source.Paths) {
+				continue
+			}
+
+			if len(source.IgnorePaths) > 0 && allFilesMatch(pull.ChangedFiles, source.IgnorePaths) {
+				continue
+			}
+		}
+
+		skip, err := skipRequested(g, source, pull)
+		if err != nil {
+			return nil, err
+		}
+		if skip {
+			continue
+		}
+
+		trusted, err := authorTrusted(g, source, pull)
+		if err != nil {
+			return nil, err
+		}
+		if !trusted {
+			continue
+		}
+
+		allowed, err := forkAllowed(g, source, pull)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			continue
+		}
+
+		approved, err := reviewApproved(g, source, pull)
+		if err != nil {
+			return nil, err
+		}
+		if !approved {
+			continue
+		}
+
+		filtered = append(filtered, pull)
+	}
+	return filtered, nil
+}
+
+func hasAllLabels(labels, wanted []string) bool {
+	for _, w := range wanted {
+		if !containsString(labels, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAnyLabel(labels, unwanted []string) bool {
+	for _, u := range unwanted {
+		if containsString(labels, u) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func anyFileMatches(files, patterns []string) bool {
+	for _, file := range files {
+		if matchesAnyPath(file, patterns) {
+			return true
+		}
+	}
+	return false
+}
+
+func allFilesMatch(files, patterns []string) bool {
+	for _, file := range files {
+		if !matchesAnyPath(file, patterns) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAnyPath reports whether file or one of its parent directories
+// matches one of the glob patterns, so a pattern naming a directory matches
+// every file below it.
+func matchesAnyPath(file string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		for p := file; p != "." && p != "/"; p = path.Dir(p) {
+			if matched, _ := path.Match(pattern, p); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
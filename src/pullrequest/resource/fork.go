@@ -0,0 +1,56 @@
+package resource
+
+import (
+	"fmt"
+)
+
+// The ways to treat pulls from forks, see Source.ForkPolicy.
+const (
+	forkPolicyAllow                 = "allow"
+	forkPolicyDisallow              = "disallow"
+	forkPolicyRequireLabel          = "require_label"
+	forkPolicyRequireApprovedReview = "require_approved_review"
+)
+
+const defaultForkLabel = "ok-to-test"
+
+// forkAllowed tells whether the fork policy of source lets pull be built.
+// Pulls from the repository itself are always allowed.
+func forkAllowed(g Github, source Source, pull *Pull) (bool, error) {
+	policy := source.ForkPolicy
+	if policy == "" {
+		policy = forkPolicyAllow
+	}
+
+	switch policy {
+	case forkPolicyAllow, forkPolicyDisallow, forkPolicyRequireLabel, forkPolicyRequireApprovedReview:
+	default:
+		return false, fmt.Errorf("%s is not a valid fork_policy", policy)
+	}
+
+	if !pull.Fork {
+		return true, nil
+	}
+
+	switch policy {
+	case forkPolicyDisallow:
+		return false, nil
+	case forkPolicyRequireLabel:
+		label := source.ForkLabel
+		if label == "" {
+			label = defaultForkLabel
+		}
+		if !containsString(pull.Labels, label) {
+			return false, nil
+		}
+		// Like approvals, labels only vouch for the head they were added to.
+		return g.LabeledSincePush(pull.Number, label)
+	case forkPolicyRequireApprovedReview:
+		reviews, err := g.ListReviews(pull.Number)
+		if err != nil {
+			return false, err
+		}
+		return approvedHead(reviews, pull.LatestCommitSHA), nil
+	}
+	return true, nil
+}
@@ -0,0 +1,260 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const maxDeepenAttempts = 10
+
+// The identity used for commits git creates itself, e.g. while rebasing or
+// merging.
+const (
+	gitCommitterName  = "concourse"
+	gitCommitterEmail = "concourse@localhost"
+)
+
+// gitRepo runs git commands against a working copy. Credentials and TLS
+// settings are handed to git through GIT_CONFIG_* environment variables so
+// they never end up on the command line, in a file or in the remote URL.
+type gitRepo struct {
+	dir      string
+	url      string
+	token    string
+	insecure bool
+}
+
+func newGitRepo(dir, token string, insecure bool) *gitRepo {
+	return &gitRepo{
+		dir:      dir,
+		token:    token,
+		insecure: insecure,
+	}
+}
+
+// Init creates an empty repository in dir with origin pointing at url.
+func (g *gitRepo) Init(url string) error {
+	if err := os.MkdirAll(g.dir, 0755); err != nil {
+		return fmt.Errorf("creating %s: %+v", g.dir, err)
+	}
+
+	if _, err := g.run("init", "-q"); err != nil {
+		return err
+	}
+	g.url = url
+
+	_, err := g.run("remote", "add", "origin", url)
+	return err
+}
+
+// Fetch fetches refspecs from origin, limited to depth commits when depth is
+// positive.
+func (g *gitRepo) Fetch(depth int, refspecs ...string) error {
+	args := []string{"fetch", "-q"}
+	if depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
+	}
+	args = append(args, "origin")
+	args = append(args, refspecs...)
+
+	_, err := g.run(args...)
+	return err
+}
+
+// DeepenToMergeBase deepens the shallow history of refspecs by depth commits
+// at a time until a and b share a merge base, giving up and fetching their
+// full history after maxDeepenAttempts.
+func (g *gitRepo) DeepenToMergeBase(depth int, a, b string, refspecs ...string) error {
+	for i := 0; i < maxDeepenAttempts; i++ {
+		if _, err := g.run("merge-base", a, b); err == nil {
+			return nil
+		}
+
+		args := append([]string{"fetch", "-q", "--deepen", strconv.Itoa(depth), "origin"}, refspecs...)
+		if _, err := g.run(args...); err != nil {
+			return err
+		}
+	}
+
+	if _, err := g.run("merge-base", a, b); err == nil {
+		return nil
+	}
+
+	shallow, err := g.run("rev-parse", "--is-shallow-repository")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(shallow) != "true" {
+		return fmt.Errorf("%s and %s have no merge base", a, b)
+	}
+
+	args := append([]string{"fetch", "-q", "--unshallow", "origin"}, refspecs...)
+	_, err = g.run(args...)
+	return err
+}
+
+// UpdateSubmodules initialises submodules according to mode, which is one of
+// none, shallow (only the submodules of the repository itself) or recursive.
+func (g *gitRepo) UpdateSubmodules(mode string, depth int) error {
+	args := []string{"submodule", "update", "--init"}
+	switch mode {
+	case "", "none":
+		return nil
+	case "shallow":
+	case "recursive":
+		args = append(args, "--recursive")
+	default:
+		return fmt.Errorf("%s is not a valid submodules mode", mode)
+	}
+
+	if depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
+	}
+
+	_, err := g.run(args...)
+	return err
+}
+
+// Rebase rebases the current branch onto upstream. When the rebase stops on
+// conflicts it is aborted and the conflicting files are returned.
+func (g *gitRepo) Rebase(upstream string) ([]string, error) {
+	_, err := g.run("rebase", "-q", upstream)
+	if err == nil {
+		return nil, nil
+	}
+
+	out, diffErr := g.run("diff", "--name-only", "--diff-filter=U")
+	if diffErr != nil || strings.TrimSpace(out) == "" {
+		return nil, err
+	}
+
+	if _, abortErr := g.run("rebase", "--abort"); abortErr != nil {
+		return nil, abortErr
+	}
+	return strings.Fields(out), nil
+}
+
+// Merge merges ref into the current branch. When the merge stops on conflicts
+// it is aborted and the conflicting files are returned.
+func (g *gitRepo) Merge(ref string) ([]string, error) {
+	_, err := g.run("merge", "-q", "--no-ff", "--no-edit", ref)
+	if err == nil {
+		return nil, nil
+	}
+
+	out, diffErr := g.run("diff", "--name-only", "--diff-filter=U")
+	if diffErr != nil || strings.TrimSpace(out) == "" {
+		return nil, err
+	}
+
+	if _, abortErr := g.run("merge", "--abort"); abortErr != nil {
+		return nil, abortErr
+	}
+	return strings.Fields(out), nil
+}
+
+// Checkout checks out ref.
+func (g *gitRepo) Checkout(ref string) error {
+	_, err := g.run("checkout", "-q", ref)
+	return err
+}
+
+// RevParse resolves ref to a commit sha.
+func (g *gitRepo) RevParse(ref string) (string, error) {
+	out, err := g.run("rev-parse", ref)
+	return strings.TrimSpace(out), err
+}
+
+// hasHead tells whether ref, fetched from the pull ref of kind head or merge,
+// is at sha or a merge of it. An empty sha is not checked.
+func (g *gitRepo) hasHead(ref, kind, sha string) bool {
+	if sha == "" {
+		return true
+	}
+
+	if kind == "head" {
+		head, err := g.RevParse(ref)
+		return err == nil && head == sha
+	}
+
+	// The parents are read from the commit itself, they may be missing from a
+	// shallow fetch.
+	commit, err := g.run("cat-file", "-p", ref)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(commit, "\nparent "+sha+"\n")
+}
+
+func (g *gitRepo) run(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.dir
+	cmd.Env = append(os.Environ(), g.env()...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running git %s: %s, %+v", args[0], g.redact(strings.TrimSpace(stderr.String())), err)
+	}
+	return stdout.String(), nil
+}
+
+func (g *gitRepo) env() []string {
+	config := [][2]string{}
+	if scope := g.credentialScope(); g.token != "" && scope != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + g.token))
+		config = append(config, [2]string{"http." + scope + ".extraHeader", "Authorization: Basic " + credentials})
+	}
+	if g.insecure {
+		config = append(config, [2]string{"http.sslVerify", "false"})
+	}
+
+	env := []string{
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_CONFIG_COUNT=" + strconv.Itoa(len(config)),
+	}
+	if os.Getenv("GIT_COMMITTER_NAME") == "" {
+		env = append(env, "GIT_COMMITTER_NAME="+gitCommitterName)
+	}
+	if os.Getenv("GIT_COMMITTER_EMAIL") == "" {
+		env = append(env, "GIT_COMMITTER_EMAIL="+gitCommitterEmail)
+	}
+	if os.Getenv("GIT_AUTHOR_NAME") == "" {
+		env = append(env, "GIT_AUTHOR_NAME="+gitCommitterName)
+	}
+	if os.Getenv("GIT_AUTHOR_EMAIL") == "" {
+		env = append(env, "GIT_AUTHOR_EMAIL="+gitCommitterEmail)
+	}
+	for i, kv := range config {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, kv[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, kv[1]),
+		)
+	}
+	return env
+}
+
+// credentialScope limits the credentials to the host of origin, so they are
+// not handed to submodules living elsewhere.
+func (g *gitRepo) credentialScope() string {
+	u, err := url.Parse(g.url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + "/"
+}
+
+func (g *gitRepo) redact(s string) string {
+	if g.token == "" {
+		return s
+	}
+	return strings.Replace(s, g.token, "[redacted]", -1)
+}
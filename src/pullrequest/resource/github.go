@@ -5,29 +5,37 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"os/exec"
+	"net/url"
 	"path"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/github"
+	"github.com/google/go-querystring/query"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 )
 
 var githubCheckContext = "concourse/ci"
 
-var downloadPRScriptPath = "/var/download_pr.sh"
-var downloadPRScriptBytes = `#!/bin/sh
-git -c http.sslVerify=false clone {{.RepoURL}} {{.DestDir}}/
-cd {{.DestDir}}
-git -c http.sslVerify=false fetch origin pull/{{.PRNumber}}/head:pr
-git checkout pr
-`
+// The states of a pull, see Source.States.
+const (
+	pullStateOpen   = "open"
+	pullStateClosed = "closed"
+	pullStateMerged = "merged"
+)
+
+// mediaTypeDraftPreview is needed for the API to report the draft state of pulls.
+const mediaTypeDraftPreview = "application/vnd.github.shadow-cat-preview+json"
+
+const (
+	defaultPerPage = 100
+	defaultMaxPRs  = 1000
+)
 
 // Pull is
 type Pull struct {
@@ -36,24 +44,151 @@ type Pull struct {
 	LatestCommitSHA string
 	URL             string
 	Body            string
-	Labels          string
+	Labels          []string
 	Title           string
+	UpdatedAt       time.Time
+	CreatedAt       time.Time
+	BaseRef         string
+	BaseSHA         string
+	PushedAt        time.Time
+	Draft           bool
+	ChangedFiles    []string
+	Mergeable       *bool
+	Author          string
+	HTMLURL         string
+	HeadRef         string
+	HeadRepo        string
+	Fork            bool
+	State           string
+	MergeCommitSHA  string
+	Status          string
+	TriggerComment  *Comment
+}
+
+// ConflictError is
+type ConflictError struct {
+	Number int
+	Mode   string
+	Base   string
+	Files  []string
+}
+
+func (e *ConflictError) Error() string {
+	msg := fmt.Sprintf("pr %d cannot be checked out with %s onto %s: it has conflicts", e.Number, e.Mode, e.Base)
+	if len(e.Files) > 0 {
+		msg += " in " + strings.Join(e.Files, ", ")
+	}
+	return msg
 }
 
 // Github is
 type Github interface {
 	ListPRs() ([]*Pull, error)
-	DownloadPR(string, int) error
-	UpdatePR(string, string, string) (string, error)
+	GetPR(int) (*Pull, error)
+	ListChangedFiles(int) ([]string, error)
+	DownloadPR(string, *Pull, InParams) error
+	GetArchiveLink(string, string) (string, error)
+	UpdatePR(string, CommitStatus, string) (string, error)
+	UpdateCheckRun(string, CheckRun, string) (string, error)
+	Login() (string, error)
+	ListComments(int) ([]*Comment, error)
+	CreateComment(int, string) (*Comment, error)
+	EditComment(int64, string) (*Comment, error)
+	AddLabels(int, []string) error
+	RemoveLabel(int, string) error
+	MergePR(int, MergeRequest) (string, error)
+	DeleteBranch(string) error
+	GetCommit(string) (*Commit, error)
+	LastForcePush(int) (time.Time, error)
+	LabeledSincePush(int, string) (bool, error)
+	ListReviews(int) ([]*Review, error)
+	IsTeamMember(string, string, string) (bool, error)
+	GetPermission(string) (string, error)
+}
+
+// Review is
+type Review struct {
+	ID                int64
+	Author            string
+	AuthorAssociation string
+	State             string
+	CommitID          string
+	SubmittedAt       time.Time
+}
+
+// Commit is
+type Commit struct {
+	SHA         string
+	Message     string
+	CommittedAt time.Time
+}
+
+// MergeRequest is
+type MergeRequest struct {
+	Method        string
+	CommitTitle   string
+	CommitMessage string
+	SHA           string
+}
+
+// mergeRequest leaves out empty fields, so GitHub falls back to its default
+// commit title and message.
+type mergeRequest struct {
+	CommitTitle   string `json:"commit_title,omitempty"`
+	CommitMessage string `json:"commit_message,omitempty"`
+	MergeMethod   string `json:"merge_method,omitempty"`
+	SHA           string `json:"sha,omitempty"`
+}
+
+type mergeResult struct {
+	SHA     string `json:"sha"`
+	Merged  bool   `json:"merged"`
+	Message string `json:"message"`
+}
+
+// Comment is
+type Comment struct {
+	ID                int64
+	Body              string
+	Author            string
+	AuthorAssociation string
+	HTMLURL           string
+	CreatedAt         time.Time
+}
+
+// CommitStatus is
+type CommitStatus struct {
+	State       string
+	Context     string
+	Description string
+	TargetURL   string
 }
 
 // GithubClient is
 type GithubClient struct {
-	client *github.Client
-	owner  string
-	repo   string
-	token  string
-	ctx    context.Context
+	client   *github.Client
+	owner    string
+	repo     string
+	token    string
+	insecure bool
+	perPage  int
+	maxPRs   int
+	states   map[string]bool
+	ctx      context.Context
+
+	// appClient authenticates as the app, when the client authenticates as
+	// one of its installations.
+	appClient *github.Client
+	login     string
+
+	// commits caches GetCommit, commits never change and many pulls share
+	// their base.
+	commits map[string]*Commit
+
+	// teamMembers and permissions cache the membership lookups for the run,
+	// most authors open more than one pull.
+	teamMembers map[string]bool
+	permissions map[string]string
 }
 
 // NewGithubClient is
@@ -69,6 +204,27 @@ func NewGithubClient(source Source) (*GithubClient, error) {
 		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
 	}
 
+	app, err := usesApp(source)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cache is keyed by the installation, its tokens change every run.
+	cacheKey := source.AccessToken
+	var appClient *github.Client
+	if app {
+		appClient, err = newAppClient(httpClient, source)
+		if err != nil {
+			return nil, err
+		}
+
+		source.AccessToken, err = installationToken(appClient, source.InstallationID)
+		if err != nil {
+			return nil, err
+		}
+		cacheKey = fmt.Sprintf("app %d installation %d", source.AppID, source.InstallationID)
+	}
+
 	if source.AccessToken != "" {
 		httpClient, err = oauthClient(ctx, source)
 		if err != nil {
@@ -76,56 +232,289 @@ func NewGithubClient(source Source) (*GithubClient, error) {
 		}
 	}
 
-	var client *github.Client
-	if source.APIURL == "" {
-		client = github.NewClient(httpClient)
-	} else {
-		client, err = github.NewEnterpriseClient(source.APIURL, source.APIURL+"/upload", httpClient)
-		if err != nil {
-			return nil, fmt.Errorf("construting enterprise oauth2 client: %+v", err)
+	maxWait, err := maxRetryWait(source)
+	if err != nil {
+		return nil, err
+	}
+	transport := httpClient.Transport
+	if !source.DisableCache {
+		cacheDir := source.CacheDir
+		if cacheDir == "" {
+			cacheDir = defaultCacheDir
 		}
+		transport = newCacheTransport(transport, cacheDir, cacheKey)
+	}
+	httpClient = &http.Client{Transport: newRetryTransport(transport, maxWait)}
+
+	client, err := newAPIClient(source, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	perPage := source.PerPage
+	if perPage <= 0 || perPage > defaultPerPage {
+		perPage = defaultPerPage
+	}
+
+	maxPRs := source.MaxPRs
+	if maxPRs <= 0 {
+		maxPRs = defaultMaxPRs
+	}
+
+	states, err := pullStates(source.States)
+	if err != nil {
+		return nil, err
 	}
 
 	return &GithubClient{
-		client: client,
-		owner:  source.Owner,
-		repo:   source.Repo,
-		token:  source.AccessToken,
+		client:   client,
+		owner:    source.Owner,
+		repo:     source.Repo,
+		token:    source.AccessToken,
+		insecure: source.Insecure,
+		perPage:  perPage,
+		maxPRs:   maxPRs,
+		states:   states,
+		commits:  map[string]*Commit{},
+
+		appClient: appClient,
+
+		teamMembers: map[string]bool{},
+		permissions: map[string]string{},
 	}, nil
 }
 
+func newAPIClient(source Source, httpClient *http.Client) (*github.Client, error) {
+	if source.APIURL == "" {
+		return github.NewClient(httpClient), nil
+	}
+
+	client, err := github.NewEnterpriseClient(source.APIURL, source.APIURL+"/upload", httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("construting enterprise oauth2 client: %+v", err)
+	}
+	return client, nil
+}
+
 // ListPRs is
 func (gc *GithubClient) ListPRs() ([]*Pull, error) {
+	// Walk the pages newest first so that hitting maxPRs drops the stalest
+	// pulls, the result is sorted back to ascending update time.
 	options := &github.PullRequestListOptions{
-		Sort:      "updated",
-		Direction: "asc",
+		State:       gc.listState(),
+		Sort:        "updated",
+		Direction:   "desc",
+		ListOptions: github.ListOptions{PerPage: gc.perPage},
+	}
+
+	seen := map[int]bool{}
+	var convertedPulls = []*Pull{}
+	for {
+		pulls, resp, err := gc.listPullRequests(options)
+		if err != nil {
+			return nil, fmt.Errorf("listing pr: %+v", err)
+		}
+
+		err = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pull := range pulls {
+			// A pull updated while we are paging moves to the front of the
+			// listing and may show up twice, the first sighting is the newest.
+			if seen[pull.GetNumber()] {
+				continue
+			}
+			seen[pull.GetNumber()] = true
+
+			converted := convertPR(pull)
+			if !gc.states[converted.State] {
+				continue
+			}
+
+			convertedPulls = append(convertedPulls, converted)
+			if len(convertedPulls) >= gc.maxPRs {
+				log.Warnf("reached max_prs limit of %d, ignoring older pull requests", gc.maxPRs)
+				return sortPulls(convertedPulls), nil
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		options.Page = resp.NextPage
+	}
+
+	return sortPulls(convertedPulls), nil
+}
+
+// pullStates turns the states of the source into a set of pull states, open
+// pulls only when none are given.
+func pullStates(states []string) (map[string]bool, error) {
+	if len(states) == 0 {
+		return map[string]bool{pullStateOpen: true}, nil
+	}
+
+	set := map[string]bool{}
+	for _, state := range states {
+		switch state {
+		case pullStateOpen, pullStateClosed, pullStateMerged:
+			set[state] = true
+		case "all":
+			set[pullStateOpen] = true
+			set[pullStateClosed] = true
+			set[pullStateMerged] = true
+		default:
+			return nil, fmt.Errorf("%s is not a valid state", state)
+		}
+	}
+	return set, nil
+}
+
+// listState is the state to list pulls in, GitHub counts merged pulls as
+// closed.
+func (gc *GithubClient) listState() string {
+	switch {
+	case !gc.states[pullStateOpen]:
+		return "closed"
+	case gc.states[pullStateClosed] || gc.states[pullStateMerged]:
+		return "all"
+	default:
+		return "open"
+	}
+}
+
+// ListChangedFiles is
+func (gc *GithubClient) ListChangedFiles(number int) ([]string, error) {
+	options := &github.ListOptions{PerPage: gc.perPage}
+
+	var files = []string{}
+	for {
+		commitFiles, resp, err := gc.client.PullRequests.ListFiles(context.TODO(), gc.owner, gc.repo, number, options)
+		if err != nil {
+			return nil, fmt.Errorf("listing files of pr %d: %+v", number, err)
+		}
+
+		err = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range commitFiles {
+			files = append(files, file.GetFilename())
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		options.Page = resp.NextPage
+	}
+
+	return files, nil
+}
+
+// pullRequest adds the fields the vendored client does not know about yet.
+type pullRequest struct {
+	github.PullRequest
+	Draft *bool `json:"draft,omitempty"`
+}
+
+// pullRequestReview adds the fields the vendored client does not know about
+// yet.
+type pullRequestReview struct {
+	github.PullRequestReview
+	AuthorAssociation string `json:"author_association"`
+}
+
+// ListReviews is
+func (gc *GithubClient) ListReviews(number int) ([]*Review, error) {
+	options := &github.ListOptions{PerPage: gc.perPage}
+
+	var reviews = []*Review{}
+	for {
+		values, err := query.Values(options)
+		if err != nil {
+			return nil, err
+		}
+
+		u := fmt.Sprintf("repos/%s/%s/pulls/%d/reviews?%s", gc.owner, gc.repo, number, values.Encode())
+		req, err := gc.client.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var page []*pullRequestReview
+		resp, err := gc.client.Do(context.TODO(), req, &page)
+		if err != nil {
+			return nil, fmt.Errorf("listing reviews of pr %d: %+v", number, err)
+		}
+
+		err = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, review := range page {
+			reviews = append(reviews, &Review{
+				ID:                review.GetID(),
+				Author:            review.GetUser().GetLogin(),
+				AuthorAssociation: review.AuthorAssociation,
+				State:             review.GetState(),
+				CommitID:          review.GetCommitID(),
+				SubmittedAt:       review.GetSubmittedAt(),
+			})
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		options.Page = resp.NextPage
 	}
 
-	pulls, resp, err := gc.client.PullRequests.List(context.TODO(), gc.owner, gc.repo, options)
+	return reviews, nil
+}
+
+func (gc *GithubClient) listPullRequests(options *github.PullRequestListOptions) ([]*pullRequest, *github.Response, error) {
+	values, err := query.Values(options)
 	if err != nil {
-		return nil, fmt.Errorf("listing pr: %+v", err)
+		return nil, nil, err
 	}
 
-	err = resp.Body.Close()
+	u := fmt.Sprintf("repos/%s/%s/pulls?%s", gc.owner, gc.repo, values.Encode())
+	req, err := gc.client.NewRequest("GET", u, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	req.Header.Set("Accept", mediaTypeDraftPreview)
 
-	var convertedPulls = []*Pull{}
-	for _, pull := range pulls {
-		convertedPulls = append(convertedPulls, convertPR(pull))
+	var pulls []*pullRequest
+	resp, err := gc.client.Do(context.TODO(), req, &pulls)
+	if err != nil {
+		return nil, resp, err
 	}
-	return convertedPulls, nil
+	return pulls, resp, nil
 }
 
-type pullFetcher struct {
-	RepoURL  string
-	DestDir  string
-	PRNumber int
+func (gc *GithubClient) getPullRequest(number int) (*pullRequest, *github.Response, error) {
+	u := fmt.Sprintf("repos/%s/%s/pulls/%d", gc.owner, gc.repo, number)
+	req, err := gc.client.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", mediaTypeDraftPreview)
+
+	pull := new(pullRequest)
+	resp, err := gc.client.Do(context.TODO(), req, pull)
+	if err != nil {
+		return nil, resp, err
+	}
+	return pull, resp, nil
 }
 
-// DownloadPR is
-func (gc *GithubClient) DownloadPR(destDir string, prNumber int) error {
+// DownloadPR checks out the head commit pull names, even when the pull moved
+// on since.
+func (gc *GithubClient) DownloadPR(destDir string, pull *Pull, params InParams) error {
 	repo, resp, err := gc.client.Repositories.Get(context.TODO(), gc.owner, gc.repo)
 	if err != nil {
 		return fmt.Errorf("getting repos: %+v", err)
@@ -135,40 +524,136 @@ func (gc *GithubClient) DownloadPR(destDir string, prNumber int) error {
 		return fmt.Errorf("closing resp body: %+v", err)
 	}
 
-	file, err := os.OpenFile(downloadPRScriptPath, os.O_RDWR|os.O_CREATE, 0777)
-	if err != nil {
-		return fmt.Errorf("opening download script: %+v", err)
+	cloneURL := repo.GetCloneURL()
+	if cloneURL == "" {
+		cloneURL = repo.GetHTMLURL()
 	}
 
-	tmpl := template.Must(template.New("").Parse(downloadPRScriptBytes))
-	pf := pullFetcher{
-		RepoURL:  buildURLWithToken(repo.GetHTMLURL(), gc.token),
-		DestDir:  destDir,
-		PRNumber: prNumber,
+	log.Infof("repo path: %s", destDir)
+
+	git := newGitRepo(destDir, gc.token, gc.insecure)
+	if err = git.Init(cloneURL); err != nil {
+		return err
 	}
 
-	if err = tmpl.Execute(file, pf); err != nil {
-		return fmt.Errorf("executing template: %+v", err)
+	if err = checkoutPR(git, pull, params); err != nil {
+		return err
 	}
 
-	log.Infof("repo path: %s", destDir)
+	if err = git.UpdateSubmodules(params.Submodules, params.Depth); err != nil {
+		return err
+	}
+
+	return writePullToFile(destDir, pull)
+}
 
-	cmd := exec.Command("/bin/sh", downloadPRScriptPath)
-	if output, err := cmd.Output(); err != nil {
-		return fmt.Errorf("executing download script: %s, %+v", string(output), err)
+func checkoutPR(git *gitRepo, pull *Pull, params InParams) error {
+	prRef := "head"
+	fetchBase := params.FetchBase
+	switch params.Checkout {
+	case "", "head":
+	case "merge":
+		if pull.MergeCommitSHA == "" && pull.Mergeable != nil && !*pull.Mergeable {
+			return &ConflictError{Number: pull.Number, Mode: "merge", Base: pull.BaseRef}
+		}
+		prRef = "merge"
+	case "rebase":
+		fetchBase = true
+	default:
+		return fmt.Errorf("%s is not a valid checkout mode", params.Checkout)
 	}
 
-	pull, err := gc.GetPR(prNumber)
-	if err != nil {
-		return fmt.Errorf("getting pr %d: %+v", prNumber, err)
+	merged := prRef == "merge" && pull.MergeCommitSHA != ""
+
+	prRefspec := fmt.Sprintf("+refs/pull/%d/%s:pr", pull.Number, prRef)
+	if merged {
+		// A merged pull is checked out at the commit it landed as on the base.
+		prRefspec = fmt.Sprintf("+%s:pr", pull.MergeCommitSHA)
 	}
 
-	return writePullToFile(destDir, pull)
+	fetchErr := git.Fetch(params.Depth, prRefspec)
+	if fetchErr != nil && (prRef == "head" || merged) {
+		return fetchErr
+	}
+
+	// The pull refs follow the pull, when it moved on or was closed since,
+	// the commit is fetched on its own and merged locally if need be.
+	mergeLocally := false
+	if !merged && (fetchErr != nil || !git.hasHead("pr", prRef, pull.LatestCommitSHA)) {
+		prRefspec = fmt.Sprintf("+%s:pr", pull.LatestCommitSHA)
+		if err := git.Fetch(params.Depth, prRefspec); err != nil {
+			return err
+		}
+
+		if prRef == "merge" {
+			mergeLocally = true
+			fetchBase = true
+		}
+	}
+
+	baseRef := "refs/remotes/origin/" + pull.BaseRef
+	if fetchBase && pull.BaseRef != "" {
+		baseRefspec := fmt.Sprintf("+refs/heads/%s:%s", pull.BaseRef, baseRef)
+		if err := git.Fetch(params.Depth, baseRefspec); err != nil {
+			return err
+		}
+
+		if params.Depth > 0 {
+			if err := git.DeepenToMergeBase(params.Depth, "pr", baseRef, prRefspec, baseRefspec); err != nil {
+				return err
+			}
+		}
+	}
+
+	if mergeLocally {
+		if err := git.Checkout(baseRef); err != nil {
+			return err
+		}
+
+		files, err := git.Merge("pr")
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			return &ConflictError{Number: pull.Number, Mode: "merge", Base: pull.BaseRef, Files: files}
+		}
+		return nil
+	}
+
+	if err := git.Checkout("pr"); err != nil {
+		return err
+	}
+
+	if params.Checkout == "rebase" {
+		files, err := git.Rebase(baseRef)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			return &ConflictError{Number: pull.Number, Mode: "rebase", Base: pull.BaseRef, Files: files}
+		}
+	}
+
+	return nil
+}
+
+// GetArchiveLink is
+func (gc *GithubClient) GetArchiveLink(format, ref string) (string, error) {
+	var archiveFormat = github.Tarball
+	if format == string(github.Zipball) {
+		archiveFormat = github.Zipball
+	}
+
+	link, _, err := gc.client.Repositories.GetArchiveLink(context.TODO(), gc.owner, gc.repo, archiveFormat, &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil {
+		return "", fmt.Errorf("getting %s link: %+v", format, err)
+	}
+	return link.String(), nil
 }
 
 // GetPR is
 func (gc *GithubClient) GetPR(number int) (*Pull, error) {
-	pull, resp, err := gc.client.PullRequests.Get(context.TODO(), gc.owner, gc.repo, number)
+	pull, resp, err := gc.getPullRequest(number)
 	if err != nil {
 		return nil, err
 	}
@@ -182,8 +667,8 @@ func (gc *GithubClient) GetPR(number int) (*Pull, error) {
 }
 
 // UpdatePR is
-func (gc *GithubClient) UpdatePR(sourceDir, status, repoPath string) (string, error) {
-	switch status {
+func (gc *GithubClient) UpdatePR(sourceDir string, status CommitStatus, repoPath string) (string, error) {
+	switch status.State {
 	case
 		"error",
 		"failure",
@@ -191,13 +676,25 @@ func (gc *GithubClient) UpdatePR(sourceDir, status, repoPath string) (string, er
 		"success":
 		break
 	default:
-		return "", fmt.Errorf("%s is not a valid status", status)
+		return "", fmt.Errorf("%s is not a valid status", status.State)
+	}
+
+	statusContext := status.Context
+	if statusContext == "" {
+		statusContext = githubCheckContext
 	}
+
 	repoStatus := &github.RepoStatus{
-		State:   &status,
-		Context: &githubCheckContext,
+		State:   &status.State,
+		Context: &statusContext,
 		Creator: &github.User{},
 	}
+	if status.Description != "" {
+		repoStatus.Description = &status.Description
+	}
+	if status.TargetURL != "" {
+		repoStatus.TargetURL = &status.TargetURL
+	}
 
 	commitHashBytes, err := ioutil.ReadFile(path.Join(sourceDir, repoPath, "pr_last_commit_hash"))
 	if err != nil {
@@ -211,7 +708,7 @@ func (gc *GithubClient) UpdatePR(sourceDir, status, repoPath string) (string, er
 	if err = resp.Body.Close(); err != nil {
 		return "", fmt.Errorf("closing resp body: %+v", err)
 	}
-	if returnedRepoStatus.GetState() != status {
+	if returnedRepoStatus.GetState() != status.State {
 		return "", errors.New("updating commit status")
 	}
 
@@ -222,6 +719,306 @@ func (gc *GithubClient) UpdatePR(sourceDir, status, repoPath string) (string, er
 	return string(idBytes), nil
 }
 
+// ListComments is
+func (gc *GithubClient) ListComments(number int) ([]*Comment, error) {
+	options := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: gc.perPage},
+	}
+
+	var comments = []*Comment{}
+	for {
+		issueComments, resp, err := gc.client.Issues.ListComments(context.TODO(), gc.owner, gc.repo, number, options)
+		if err != nil {
+			return nil, fmt.Errorf("listing comments of pr %d: %+v", number, err)
+		}
+
+		err = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, comment := range issueComments {
+			comments = append(comments, convertComment(comment))
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		options.Page = resp.NextPage
+	}
+
+	return comments, nil
+}
+
+// Login returns the login of the user the client acts as, the bot user of
+// the app when authenticating as an installation.
+func (gc *GithubClient) Login() (string, error) {
+	if gc.login != "" {
+		return gc.login, nil
+	}
+
+	if gc.appClient != nil {
+		login, err := appLogin(gc.appClient)
+		if err != nil {
+			return "", err
+		}
+		gc.login = login
+		return login, nil
+	}
+
+	user, resp, err := gc.client.Users.Get(context.TODO(), "")
+	if err != nil {
+		return "", fmt.Errorf("getting authenticated user: %+v", err)
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return "", fmt.Errorf("closing resp body: %+v", err)
+	}
+
+	gc.login = user.GetLogin()
+	return gc.login, nil
+}
+
+// CreateComment is
+func (gc *GithubClient) CreateComment(number int, body string) (*Comment, error) {
+	comment, resp, err := gc.client.Issues.CreateComment(context.TODO(), gc.owner, gc.repo, number, &github.IssueComment{Body: &body})
+	if err != nil {
+		return nil, fmt.Errorf("creating comment on pr %d: %+v", number, err)
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return nil, fmt.Errorf("closing resp body: %+v", err)
+	}
+	return convertComment(comment), nil
+}
+
+// EditComment is
+func (gc *GithubClient) EditComment(id int64, body string) (*Comment, error) {
+	comment, resp, err := gc.client.Issues.EditComment(context.TODO(), gc.owner, gc.repo, id, &github.IssueComment{Body: &body})
+	if err != nil {
+		return nil, fmt.Errorf("editing comment %d: %+v", id, err)
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return nil, fmt.Errorf("closing resp body: %+v", err)
+	}
+	return convertComment(comment), nil
+}
+
+// AddLabels is
+func (gc *GithubClient) AddLabels(number int, labels []string) error {
+	_, resp, err := gc.client.Issues.AddLabelsToIssue(context.TODO(), gc.owner, gc.repo, number, labels)
+	if err != nil {
+		return fmt.Errorf("adding labels to pr %d: %+v", number, err)
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return fmt.Errorf("closing resp body: %+v", err)
+	}
+	return nil
+}
+
+// RemoveLabel removes label from pr number, removing a label the pull does
+// not carry is not an error.
+func (gc *GithubClient) RemoveLabel(number int, label string) error {
+	resp, err := gc.client.Issues.RemoveLabelForIssue(context.TODO(), gc.owner, gc.repo, number, url.PathEscape(label))
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("removing label %s from pr %d: %+v", label, number, err)
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return fmt.Errorf("closing resp body: %+v", err)
+	}
+	return nil
+}
+
+// MergePR merges pr number and returns the sha of the merge commit.
+func (gc *GithubClient) MergePR(number int, merge MergeRequest) (string, error) {
+	u := fmt.Sprintf("repos/%s/%s/pulls/%d/merge", gc.owner, gc.repo, number)
+	req, err := gc.client.NewRequest("PUT", u, &mergeRequest{
+		CommitTitle:   merge.CommitTitle,
+		CommitMessage: merge.CommitMessage,
+		MergeMethod:   merge.Method,
+		SHA:           merge.SHA,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	result := new(mergeResult)
+	if _, err = gc.client.Do(context.TODO(), req, result); err != nil {
+		return "", fmt.Errorf("merging pr %d: %+v", number, err)
+	}
+
+	if !result.Merged {
+		return "", fmt.Errorf("merging pr %d: %s", number, result.Message)
+	}
+	return result.SHA, nil
+}
+
+// DeleteBranch is
+func (gc *GithubClient) DeleteBranch(branch string) error {
+	resp, err := gc.client.Git.DeleteRef(context.TODO(), gc.owner, gc.repo, "heads/"+branch)
+	if err != nil {
+		return fmt.Errorf("deleting branch %s: %+v", branch, err)
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return fmt.Errorf("closing resp body: %+v", err)
+	}
+	return nil
+}
+
+// GetCommit is
+func (gc *GithubClient) GetCommit(sha string) (*Commit, error) {
+	if commit, ok := gc.commits[sha]; ok {
+		return commit, nil
+	}
+
+	gitCommit, resp, err := gc.client.Git.GetCommit(context.TODO(), gc.owner, gc.repo, sha)
+	if err != nil {
+		return nil, fmt.Errorf("getting commit %s: %+v", sha, err)
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return nil, fmt.Errorf("closing resp body: %+v", err)
+	}
+
+	commit := &Commit{
+		SHA:         gitCommit.GetSHA(),
+		Message:     gitCommit.GetMessage(),
+		CommittedAt: gitCommit.GetCommitter().GetDate(),
+	}
+	gc.commits[sha] = commit
+	return commit, nil
+}
+
+// LastForcePush returns the time pr number was last force pushed, which is
+// zero when it never was.
+func (gc *GithubClient) LastForcePush(number int) (time.Time, error) {
+	options := &github.ListOptions{PerPage: gc.perPage}
+
+	var last time.Time
+	for {
+		events, resp, err := gc.client.Issues.ListIssueEvents(context.TODO(), gc.owner, gc.repo, number, options)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("listing events of pr %d: %+v", number, err)
+		}
+
+		err = resp.Body.Close()
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		for _, event := range events {
+			if event.GetEvent() == "head_ref_force_pushed" && event.GetCreatedAt().After(last) {
+				last = event.GetCreatedAt()
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		options.Page = resp.NextPage
+	}
+
+	return last, nil
+}
+
+// LabeledSincePush tells whether label was added to pr number after its
+// head was last pushed to. The timeline lists the commits of a push where
+// they were pushed, following the labels added before.
+func (gc *GithubClient) LabeledSincePush(number int, label string) (bool, error) {
+	options := &github.ListOptions{PerPage: gc.perPage}
+
+	labeled := false
+	for {
+		events, resp, err := gc.client.Issues.ListIssueTimeline(context.TODO(), gc.owner, gc.repo, number, options)
+		if err != nil {
+			return false, fmt.Errorf("listing timeline of pr %d: %+v", number, err)
+		}
+
+		err = resp.Body.Close()
+		if err != nil {
+			return false, err
+		}
+
+		for _, event := range events {
+			switch event.GetEvent() {
+			case "labeled", "unlabeled":
+				if event.GetLabel().GetName() == label {
+					labeled = event.GetEvent() == "labeled"
+				}
+			case "committed", "head_ref_force_pushed":
+				labeled = false
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		options.Page = resp.NextPage
+	}
+
+	return labeled, nil
+}
+
+// IsTeamMember tells whether user is a member of team in org, pending
+// invitations do not count.
+func (gc *GithubClient) IsTeamMember(org, team, user string) (bool, error) {
+	key := strings.ToLower(org + "/" + team + "/" + user)
+	if member, ok := gc.teamMembers[key]; ok {
+		return member, nil
+	}
+
+	u := fmt.Sprintf("orgs/%s/teams/%s/memberships/%s", org, team, user)
+	req, err := gc.client.NewRequest("GET", u, nil)
+	if err != nil {
+		return false, err
+	}
+
+	membership := new(github.Membership)
+	resp, err := gc.client.Do(context.TODO(), req, membership)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		gc.teamMembers[key] = false
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("getting membership of %s in %s/%s: %+v", user, org, team, err)
+	}
+
+	gc.teamMembers[key] = membership.GetState() == "active"
+	return gc.teamMembers[key], nil
+}
+
+// GetPermission returns the permission user has on the repository, which is
+// none for users without access.
+func (gc *GithubClient) GetPermission(user string) (string, error) {
+	key := strings.ToLower(user)
+	if permission, ok := gc.permissions[key]; ok {
+		return permission, nil
+	}
+
+	level, resp, err := gc.client.Repositories.GetPermissionLevel(context.TODO(), gc.owner, gc.repo, user)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		gc.permissions[key] = "none"
+		return "none", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting permission of %s: %+v", user, err)
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return "", fmt.Errorf("closing resp body: %+v", err)
+	}
+
+	gc.permissions[key] = level.GetPermission()
+	return gc.permissions[key], nil
+}
+
 func oauthClient(ctx context.Context, source Source) (*http.Client, error) {
 	ts := oauth2.StaticTokenSource(&oauth2.Token{
 		AccessToken: source.AccessToken,
@@ -235,12 +1032,13 @@ func oauthClient(ctx context.Context, source Source) (*http.Client, error) {
 	return githubHTTPClient, nil
 }
 
-func buildURLWithToken(url, token string) string {
-	return fmt.Sprintf("https://%s@%s", token, url[8:])
-}
-
 func writePullToFile(destDir string, pull *Pull) error {
-	if err := writeToFile(destDir, "pr_labels", pull.Labels); err != nil {
+	var labels string
+	for _, label := range pull.Labels {
+		labels += label + "\n"
+	}
+
+	if err := writeToFile(destDir, "pr_labels", labels); err != nil {
 		return err
 	}
 
@@ -275,19 +1073,59 @@ func writeToFile(destDir, fileName, content string) error {
 	return nil
 }
 
-func convertPR(pr *github.PullRequest) *Pull {
-	var labels string
+func convertPR(pr *pullRequest) *Pull {
+	var labels = []string{}
 	for _, label := range pr.Labels {
-		labels += label.GetName() + "\n"
+		labels = append(labels, label.GetName())
+	}
+
+	// Open pulls have a merge commit sha as well, for the test merge GitHub
+	// made, it only names the commit on the base once merged.
+	state := pr.GetState()
+	var mergeCommitSHA string
+	if pr.MergedAt != nil || pr.GetMerged() {
+		state = pullStateMerged
+		mergeCommitSHA = pr.GetMergeCommitSHA()
 	}
 
 	return &Pull{
 		Number:          pr.GetNumber(),
 		LatestCommitSHA: pr.GetHead().GetSHA(),
-		Ref:             fmt.Sprintf("%s-%s", pr.GetHead().GetSHA()[0:7], pr.GetUpdatedAt().Format(time.RFC3339)),
+		Ref:             pr.GetHead().GetSHA(),
 		URL:             pr.GetURL(),
 		Title:           pr.GetTitle(),
 		Body:            pr.GetBody(),
 		Labels:          labels,
+		UpdatedAt:       pr.GetUpdatedAt(),
+		CreatedAt:       pr.GetCreatedAt(),
+		BaseRef:         pr.GetBase().GetRef(),
+		BaseSHA:         pr.GetBase().GetSHA(),
+		Draft:           pr.Draft != nil && *pr.Draft,
+		Mergeable:       pr.Mergeable,
+		Author:          pr.GetUser().GetLogin(),
+		HTMLURL:         pr.GetHTMLURL(),
+		HeadRef:         pr.GetHead().GetRef(),
+		HeadRepo:        pr.GetHead().GetRepo().GetFullName(),
+		Fork:            pr.GetHead().GetRepo().GetFullName() != pr.GetBase().GetRepo().GetFullName(),
+		State:           state,
+		MergeCommitSHA:  mergeCommitSHA,
+	}
+}
+
+func convertComment(comment *github.IssueComment) *Comment {
+	return &Comment{
+		ID:                comment.GetID(),
+		Body:              comment.GetBody(),
+		Author:            comment.GetUser().GetLogin(),
+		AuthorAssociation: comment.GetAuthorAssociation(),
+		HTMLURL:           comment.GetHTMLURL(),
+		CreatedAt:         comment.GetCreatedAt(),
 	}
 }
+
+func sortPulls(pulls []*Pull) []*Pull {
+	sort.SliceStable(pulls, func(i, j int) bool {
+		return pulls[i].UpdatedAt.Before(pulls[j].UpdatedAt)
+	})
+	return pulls
+}
@@ -0,0 +1,1821 @@
+package resource_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	r "pullrequest/resource"
+)
+
+var _ = Describe("GithubClient", func() {
+	var server *httptest.Server
+	var mux *http.ServeMux
+	var source r.Source
+
+	BeforeEach(func() {
+		mux = http.NewServeMux()
+		server = httptest.NewServer(mux)
+		source = r.Source{
+			Owner:  "fake-owner",
+			Repo:   "fake-repo",
+			APIURL: server.URL,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("ListPRs", func() {
+		var requestedPages []string
+		var perPage []string
+		var total int
+
+		BeforeEach(func() {
+			requestedPages = []string{}
+			perPage = []string{}
+			total = 120
+
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls", func(w http.ResponseWriter, req *http.Request) {
+				query := req.URL.Query()
+				requestedPages = append(requestedPages, query.Get("page"))
+				perPage = append(perPage, query.Get("per_page"))
+				Expect(query.Get("sort")).To(Equal("updated"))
+				Expect(query.Get("direction")).To(Equal("desc"))
+
+				size, _ := strconv.Atoi(query.Get("per_page"))
+				page, _ := strconv.Atoi(query.Get("page"))
+				if page == 0 {
+					page = 1
+				}
+
+				start := (page - 1) * size
+				end := start + size
+				if end >= total {
+					end = total
+				} else {
+					w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=%d>; rel="next"`, server.URL, req.URL.Path, page+1))
+				}
+
+				updated := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+				body := "["
+				for i := start; i < end; i++ {
+					if i > start {
+						body += ","
+					}
+					number := total - i
+					body += fmt.Sprintf(`{"number":%d,"state":"open","head":{"sha":"abcdef0%d"},"updated_at":"%s"}`,
+						number, number, updated.Add(time.Duration(number)*time.Minute).Format(time.RFC3339))
+				}
+				body += "]"
+				fmt.Fprint(w, body)
+			})
+		})
+
+		It("should walk every page", func() {
+			source.PerPage = 50
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			pulls, err := client.ListPRs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pulls).To(HaveLen(120))
+			Expect(requestedPages).To(Equal([]string{"", "2", "3"}))
+			Expect(perPage).To(Equal([]string{"50", "50", "50"}))
+		})
+
+		It("should return pulls in ascending update order", func() {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			pulls, err := client.ListPRs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pulls).To(HaveLen(120))
+			for i := 1; i < len(pulls); i++ {
+				Expect(pulls[i-1].UpdatedAt.Before(pulls[i].UpdatedAt)).To(BeTrue())
+			}
+			Expect(pulls[0].Number).To(Equal(1))
+			Expect(pulls[119].Number).To(Equal(120))
+		})
+
+		It("should default per_page to the maximum", func() {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.ListPRs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(perPage).To(Equal([]string{"100", "100"}))
+		})
+
+		It("should stop at max_prs keeping the most recently updated", func() {
+			source.PerPage = 30
+			source.MaxPRs = 45
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			pulls, err := client.ListPRs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pulls).To(HaveLen(45))
+			Expect(requestedPages).To(Equal([]string{"", "2"}))
+			Expect(pulls[0].Number).To(Equal(76))
+			Expect(pulls[44].Number).To(Equal(120))
+		})
+
+		It("should return error when a page fails", func() {
+			mux = http.NewServeMux()
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls", func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			})
+			server.Config.Handler = mux
+			source.MaxRetryWait = "0s"
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.ListPRs()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("listing pr:"))
+		})
+	})
+
+	Describe("ListPRs fields", func() {
+		It("should convert base and head refs, draft state and labels", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Header.Get("Accept")).To(ContainSubstring("shadow-cat-preview"))
+				fmt.Fprint(w, `[{"number":7,"state":"open","draft":true,"head":{"sha":"abcdef01234","ref":"feature","repo":{"full_name":"someone/fake-repo"}},"base":{"ref":"develop","sha":"0123456789"},"labels":[{"name":"ready"},{"name":"wip"}]}]`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			pulls, err := client.ListPRs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pulls).To(HaveLen(1))
+			Expect(pulls[0].Ref).To(Equal("abcdef01234"))
+			Expect(pulls[0].BaseRef).To(Equal("develop"))
+			Expect(pulls[0].BaseSHA).To(Equal("0123456789"))
+			Expect(pulls[0].HeadRef).To(Equal("feature"))
+			Expect(pulls[0].HeadRepo).To(Equal("someone/fake-repo"))
+			Expect(pulls[0].Draft).To(BeTrue())
+			Expect(pulls[0].Labels).To(Equal([]string{"ready", "wip"}))
+		})
+
+		It("should tell forks apart by their head repository", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls", func(w http.ResponseWriter, req *http.Request) {
+				fmt.Fprint(w, `[
+					{"number":1,"state":"open","head":{"sha":"sha1","repo":{"full_name":"fake-owner/fake-repo"}},"base":{"repo":{"full_name":"fake-owner/fake-repo"}}},
+					{"number":2,"state":"open","head":{"sha":"sha2","repo":{"full_name":"someone/fake-repo"}},"base":{"repo":{"full_name":"fake-owner/fake-repo"}}},
+					{"number":3,"state":"open","head":{"sha":"sha3","repo":null},"base":{"repo":{"full_name":"fake-owner/fake-repo"}}}
+				]`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			pulls, err := client.ListPRs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pulls).To(HaveLen(3))
+			Expect(pulls[0].Fork).To(BeFalse())
+			Expect(pulls[1].Fork).To(BeTrue())
+			Expect(pulls[2].Fork).To(BeTrue())
+		})
+	})
+
+	Describe("ListPRs states", func() {
+		var listedState string
+
+		BeforeEach(func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls", func(w http.ResponseWriter, req *http.Request) {
+				listedState = req.URL.Query().Get("state")
+				fmt.Fprint(w, `[
+					{"number":1,"state":"open","head":{"sha":"sha1"},"merge_commit_sha":"test-merge1"},
+					{"number":2,"state":"closed","head":{"sha":"sha2"},"merge_commit_sha":"test-merge2"},
+					{"number":3,"state":"closed","head":{"sha":"sha3"},"merged_at":"2018-06-01T12:30:00Z","merge_commit_sha":"merge3"}
+				]`)
+			})
+		})
+
+		numbers := func(pulls []*r.Pull) []int {
+			result := []int{}
+			for _, pull := range pulls {
+				result = append(result, pull.Number)
+			}
+			return result
+		}
+
+		It("should list open pulls by default", func() {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			pulls, err := client.ListPRs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listedState).To(Equal("open"))
+			Expect(numbers(pulls)).To(Equal([]int{1}))
+			Expect(pulls[0].State).To(Equal("open"))
+			Expect(pulls[0].MergeCommitSHA).To(BeEmpty())
+		})
+
+		It("should list merged pulls only", func() {
+			source.States = []string{"merged"}
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			pulls, err := client.ListPRs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listedState).To(Equal("closed"))
+			Expect(numbers(pulls)).To(Equal([]int{3}))
+			Expect(pulls[0].State).To(Equal("merged"))
+			Expect(pulls[0].MergeCommitSHA).To(Equal("merge3"))
+		})
+
+		It("should list open and closed pulls", func() {
+			source.States = []string{"open", "closed"}
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			pulls, err := client.ListPRs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listedState).To(Equal("all"))
+			Expect(numbers(pulls)).To(Equal([]int{1, 2}))
+		})
+
+		It("should list pulls in every state", func() {
+			source.States = []string{"all"}
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			pulls, err := client.ListPRs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listedState).To(Equal("all"))
+			Expect(numbers(pulls)).To(Equal([]int{1, 2, 3}))
+		})
+
+		It("should return error for an unknown state", func() {
+			source.States = []string{"fake-state"}
+			_, err := r.NewGithubClient(source)
+			Expect(err).To(MatchError("fake-state is not a valid state"))
+		})
+	})
+
+	Describe("ListReviews", func() {
+		It("should list the reviews of every page", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls/1/reviews", func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Query().Get("page") == "2" {
+					fmt.Fprint(w, `[{"id":2,"user":{"login":"bob"},"state":"CHANGES_REQUESTED","commit_id":"fake-sha1"}]`)
+					return
+				}
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next"`, server.URL, req.URL.Path))
+				fmt.Fprint(w, `[{"id":1,"user":{"login":"alice"},"author_association":"MEMBER","state":"APPROVED","commit_id":"fake-sha1","submitted_at":"2018-06-01T12:30:00Z"}]`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			reviews, err := client.ListReviews(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reviews).To(HaveLen(2))
+			Expect(*reviews[0]).To(Equal(r.Review{
+				ID:                1,
+				Author:            "alice",
+				AuthorAssociation: "MEMBER",
+				State:             "APPROVED",
+				CommitID:          "fake-sha1",
+				SubmittedAt:       time.Date(2018, 6, 1, 12, 30, 0, 0, time.UTC),
+			}))
+			Expect(reviews[1].State).To(Equal("CHANGES_REQUESTED"))
+		})
+	})
+
+	Describe("membership", func() {
+		It("should look up team membership once per run", func() {
+			requests := 0
+			mux.HandleFunc("/orgs/fake-org/teams/core/memberships/alice", func(w http.ResponseWriter, req *http.Request) {
+				requests++
+				fmt.Fprint(w, `{"state":"active","role":"member"}`)
+			})
+			mux.HandleFunc("/orgs/fake-org/teams/core/memberships/bob", func(w http.ResponseWriter, req *http.Request) {
+				fmt.Fprint(w, `{"state":"pending","role":"member"}`)
+			})
+			mux.HandleFunc("/orgs/fake-org/teams/core/memberships/carol", func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"message":"Not Found"}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 2; i++ {
+				member, err := client.IsTeamMember("fake-org", "core", "alice")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(member).To(BeTrue())
+			}
+			Expect(requests).To(Equal(1))
+
+			member, err := client.IsTeamMember("fake-org", "core", "bob")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(member).To(BeFalse())
+
+			member, err = client.IsTeamMember("fake-org", "core", "carol")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(member).To(BeFalse())
+		})
+
+		It("should look up collaborator permissions once per run", func() {
+			requests := 0
+			mux.HandleFunc("/repos/fake-owner/fake-repo/collaborators/alice/permission", func(w http.ResponseWriter, req *http.Request) {
+				requests++
+				fmt.Fprint(w, `{"permission":"write","user":{"login":"alice"}}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, user := range []string{"alice", "Alice"} {
+				permission, err := client.GetPermission(user)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(permission).To(Equal("write"))
+			}
+			Expect(requests).To(Equal(1))
+		})
+
+		It("should return error when the permission lookup fails", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/collaborators/alice/permission", func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"message":"fake-message"}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.GetPermission("alice")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("getting permission of alice:"))
+		})
+	})
+
+	Describe("ListChangedFiles", func() {
+		It("should walk every page of files", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls/7/files", func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Query().Get("page") == "2" {
+					fmt.Fprint(w, `[{"filename":"src/main.go"}]`)
+					return
+				}
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next"`, server.URL, req.URL.Path))
+				fmt.Fprint(w, `[{"filename":"README.md"},{"filename":"docs/index.md"}]`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			files, err := client.ListChangedFiles(7)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{"README.md", "docs/index.md", "src/main.go"}))
+		})
+	})
+
+	Describe("DownloadPR", func() {
+		var fixtureDir string
+		var bareDir string
+		var destDir string
+		var prSHA string
+		var conflictSHA string
+		var conflictMergeable bool
+
+		BeforeEach(func() {
+			var err error
+			fixtureDir, err = ioutil.TempDir("", "git-fixture")
+			Expect(err).ToNot(HaveOccurred())
+
+			bareDir, prSHA = createBareRepo(fixtureDir)
+			conflictSHA = runGit(bareDir, "rev-parse", "refs/pull/2/head")
+			conflictMergeable = false
+			destDir = path.Join(fixtureDir, "dest")
+
+			mux.HandleFunc("/repos/fake-owner/fake-repo", func(w http.ResponseWriter, req *http.Request) {
+				fmt.Fprintf(w, `{"clone_url":%q}`, bareDir)
+			})
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls/1", func(w http.ResponseWriter, req *http.Request) {
+				fmt.Fprintf(w, `{"number":1,"title":"fake-title","head":{"sha":%q},"base":{"ref":"master"},"labels":[{"name":"ready"}],"mergeable":true}`, prSHA)
+			})
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls/2", func(w http.ResponseWriter, req *http.Request) {
+				fmt.Fprintf(w, `{"number":2,"head":{"sha":%q},"base":{"ref":"master"},"mergeable":%t}`, conflictSHA, conflictMergeable)
+			})
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(fixtureDir)
+		})
+
+		client := func() *r.GithubClient {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+			return client
+		}
+
+		It("should check out the pr head", func() {
+			source.AccessToken = "fake-secret-token"
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.DownloadPR(destDir, getPull(client, 1), r.InParams{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(runGit(destDir, "rev-parse", "HEAD")).To(Equal(prSHA))
+			content, err := ioutil.ReadFile(path.Join(destDir, "feature.txt"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("feature\n"))
+
+			title, err := ioutil.ReadFile(path.Join(destDir, "pr_title"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(title)).To(Equal("fake-title"))
+		})
+
+		It("should check out the requested commit when the pr moved on", func() {
+			pull := getPull(client(), 1)
+			movedSHA := runGit(bareDir, "commit-tree", prSHA+"^{tree}", "-p", prSHA, "-m", "moved")
+			runGit(bareDir, "update-ref", "refs/pull/1/head", movedSHA)
+
+			err := client().DownloadPR(destDir, pull, r.InParams{Depth: 1})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(runGit(destDir, "rev-parse", "HEAD")).To(Equal(prSHA))
+		})
+
+		It("should check out the requested commit when the pr was closed", func() {
+			pull := getPull(client(), 1)
+			runGit(bareDir, "update-ref", "-d", "refs/pull/1/merge")
+
+			err := client().DownloadPR(destDir, pull, r.InParams{Checkout: "merge"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(runGit(destDir, "rev-parse", "HEAD^2")).To(Equal(prSHA))
+		})
+
+		It("should not persist the token", func() {
+			source.AccessToken = "fake-secret-token"
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.DownloadPR(destDir, getPull(client, 1), r.InParams{})
+			Expect(err).ToNot(HaveOccurred())
+
+			config, err := ioutil.ReadFile(path.Join(destDir, ".git", "config"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(config)).ToNot(ContainSubstring("fake-secret-token"))
+		})
+
+		It("should fetch the full history of the pr head only", func() {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.DownloadPR(destDir, getPull(client, 1), r.InParams{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(runGit(destDir, "rev-list", "--count", "HEAD")).To(Equal("4"))
+			Expect(runGit(destDir, "rev-parse", "--is-shallow-repository")).To(Equal("false"))
+			Expect(runGit(destDir, "branch", "-r")).To(BeEmpty())
+			Expect(path.Join(destDir, "sub", "sub.txt")).ToNot(BeAnExistingFile())
+		})
+
+		It("should fetch only depth commits", func() {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.DownloadPR(destDir, getPull(client, 1), r.InParams{Depth: 1})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(runGit(destDir, "rev-parse", "HEAD")).To(Equal(prSHA))
+			Expect(runGit(destDir, "rev-list", "--count", "HEAD")).To(Equal("1"))
+			Expect(runGit(destDir, "rev-parse", "--is-shallow-repository")).To(Equal("true"))
+		})
+
+		It("should fetch the base branch down to the merge base", func() {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.DownloadPR(destDir, getPull(client, 1), r.InParams{Depth: 1, FetchBase: true})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(runGit(destDir, "rev-parse", "HEAD")).To(Equal(prSHA))
+			mergeBase := runGit(destDir, "merge-base", "HEAD", "origin/master")
+			Expect(runGit(destDir, "log", "-1", "--format=%s", mergeBase)).To(Equal("add README.md"))
+		})
+
+		Context("when checking out the merge", func() {
+			It("should check out github's merge commit", func() {
+				client, err := r.NewGithubClient(source)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = client.DownloadPR(destDir, getPull(client, 1), r.InParams{Checkout: "merge"})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(runGit(destDir, "rev-parse", "HEAD")).To(Equal(runGit(bareDir, "rev-parse", "refs/pull/1/merge")))
+				Expect(path.Join(destDir, "master1.txt")).To(BeAnExistingFile())
+				Expect(path.Join(destDir, "feature.txt")).To(BeAnExistingFile())
+
+				lastCommitHash, err := ioutil.ReadFile(path.Join(destDir, "pr_last_commit_hash"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(lastCommitHash)).To(Equal(prSHA))
+			})
+
+			It("should check out the merge commit of a merged pr", func() {
+				pull := getPull(client(), 1)
+				pull.State = "merged"
+				pull.MergeCommitSHA = runGit(bareDir, "rev-parse", "master")
+				runGit(bareDir, "update-ref", "-d", "refs/pull/1/merge")
+
+				err := client().DownloadPR(destDir, pull, r.InParams{Checkout: "merge"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(runGit(destDir, "rev-parse", "HEAD")).To(Equal(pull.MergeCommitSHA))
+			})
+
+			It("should fail when the pr is not mergeable", func() {
+				client, err := r.NewGithubClient(source)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = client.DownloadPR(destDir, getPull(client, 2), r.InParams{Checkout: "merge"})
+				Expect(err).To(MatchError("pr 2 cannot be checked out with merge onto master: it has conflicts"))
+			})
+
+			It("should merge locally when the merge ref is missing", func() {
+				conflictMergeable = true
+				client, err := r.NewGithubClient(source)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = client.DownloadPR(destDir, getPull(client, 2), r.InParams{Checkout: "merge"})
+				Expect(err).To(MatchError("pr 2 cannot be checked out with merge onto master: it has conflicts in README.md"))
+			})
+
+			It("should merge the requested commit locally when the pr moved on", func() {
+				pull := getPull(client(), 1)
+				movedSHA := runGit(bareDir, "commit-tree", prSHA+"^{tree}", "-p", prSHA, "-m", "moved")
+				movedMergeSHA := runGit(bareDir, "commit-tree", "refs/pull/1/merge^{tree}", "-p", "master", "-p", movedSHA, "-m", "merge moved")
+				runGit(bareDir, "update-ref", "refs/pull/1/head", movedSHA)
+				runGit(bareDir, "update-ref", "refs/pull/1/merge", movedMergeSHA)
+
+				err := client().DownloadPR(destDir, pull, r.InParams{Checkout: "merge", Depth: 1})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(runGit(destDir, "rev-parse", "HEAD^1")).To(Equal(runGit(bareDir, "rev-parse", "master")))
+				Expect(runGit(destDir, "rev-parse", "HEAD^2")).To(Equal(prSHA))
+				Expect(path.Join(destDir, "master1.txt")).To(BeAnExistingFile())
+				Expect(path.Join(destDir, "feature.txt")).To(BeAnExistingFile())
+			})
+		})
+
+		Context("when rebasing onto the base", func() {
+			It("should rebase the pr head onto the current base", func() {
+				client, err := r.NewGithubClient(source)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = client.DownloadPR(destDir, getPull(client, 1), r.InParams{Checkout: "rebase", Depth: 1})
+				Expect(err).ToNot(HaveOccurred())
+
+				masterSHA := runGit(bareDir, "rev-parse", "master")
+				Expect(runGit(destDir, "merge-base", "HEAD", "origin/master")).To(Equal(masterSHA))
+				Expect(runGit(destDir, "log", "-1", "--format=%s")).To(Equal("add feature.txt"))
+				Expect(runGit(destDir, "rev-parse", "HEAD")).ToNot(Equal(prSHA))
+				Expect(path.Join(destDir, "master1.txt")).To(BeAnExistingFile())
+			})
+
+			It("should fail listing the conflicting files", func() {
+				client, err := r.NewGithubClient(source)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = client.DownloadPR(destDir, getPull(client, 2), r.InParams{Checkout: "rebase"})
+				Expect(err).To(MatchError("pr 2 cannot be checked out with rebase onto master: it has conflicts in README.md"))
+				Expect(err).To(BeAssignableToTypeOf(&r.ConflictError{}))
+				Expect(path.Join(destDir, ".git", "rebase-merge")).ToNot(BeAnExistingFile())
+			})
+		})
+
+		It("should return error for an unknown checkout mode", func() {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.DownloadPR(destDir, getPull(client, 1), r.InParams{Checkout: "fake-mode"})
+			Expect(err).To(MatchError("fake-mode is not a valid checkout mode"))
+		})
+
+		Context("when submodules are requested", func() {
+			BeforeEach(func() {
+				os.Setenv("GIT_ALLOW_PROTOCOL", "file")
+			})
+
+			AfterEach(func() {
+				os.Unsetenv("GIT_ALLOW_PROTOCOL")
+			})
+
+			It("should only initialise top level submodules when shallow", func() {
+				client, err := r.NewGithubClient(source)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = client.DownloadPR(destDir, getPull(client, 1), r.InParams{Submodules: "shallow"})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(path.Join(destDir, "sub", "sub.txt")).To(BeAnExistingFile())
+				Expect(path.Join(destDir, "sub", "nested", "nested.txt")).ToNot(BeAnExistingFile())
+			})
+
+			It("should initialise nested submodules when recursive", func() {
+				client, err := r.NewGithubClient(source)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = client.DownloadPR(destDir, getPull(client, 1), r.InParams{Submodules: "recursive"})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(path.Join(destDir, "sub", "sub.txt")).To(BeAnExistingFile())
+				Expect(path.Join(destDir, "sub", "nested", "nested.txt")).To(BeAnExistingFile())
+			})
+
+			It("should return error for an unknown mode", func() {
+				client, err := r.NewGithubClient(source)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = client.DownloadPR(destDir, getPull(client, 1), r.InParams{Submodules: "fake-mode"})
+				Expect(err).To(MatchError("fake-mode is not a valid submodules mode"))
+			})
+		})
+
+		It("should return error without leaking the token when the pr does not exist", func() {
+			source.AccessToken = "fake-secret-token"
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls/3", func(w http.ResponseWriter, req *http.Request) {
+				fmt.Fprint(w, `{"number":3,"head":{"sha":"abcdef0123"}}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.DownloadPR(destDir, getPull(client, 3), r.InParams{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("running git fetch:"))
+			Expect(err.Error()).ToNot(ContainSubstring("fake-secret-token"))
+		})
+	})
+
+	Describe("UpdatePR", func() {
+		var srcDir string
+
+		BeforeEach(func() {
+			var err error
+			srcDir, err = ioutil.TempDir("", "update-pr")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(os.MkdirAll(path.Join(srcDir, "pr"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path.Join(srcDir, "pr", "pr_last_commit_hash"), []byte("fake-sha1"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(path.Join(srcDir, "pr", "pr_id"), []byte("fake-ref1"), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(srcDir)
+		})
+
+		It("should post the status to the head commit", func() {
+			var body map[string]interface{}
+			mux.HandleFunc("/repos/fake-owner/fake-repo/statuses/fake-sha1", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Method).To(Equal("POST"))
+				Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+				fmt.Fprint(w, `{"state":"success"}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			ref, err := client.UpdatePR(srcDir, r.CommitStatus{
+				State:       "success",
+				Context:     "ci/unit",
+				Description: "fake-description",
+				TargetURL:   "https://ci.example.com/builds/42",
+			}, "pr")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ref).To(Equal("fake-ref1"))
+			Expect(body).To(HaveKeyWithValue("state", "success"))
+			Expect(body).To(HaveKeyWithValue("context", "ci/unit"))
+			Expect(body).To(HaveKeyWithValue("description", "fake-description"))
+			Expect(body).To(HaveKeyWithValue("target_url", "https://ci.example.com/builds/42"))
+		})
+
+		It("should return error for an invalid state", func() {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.UpdatePR(srcDir, r.CommitStatus{State: "fake-state"}, "pr")
+			Expect(err).To(MatchError("fake-state is not a valid status"))
+		})
+	})
+
+	Describe("UpdateCheckRun", func() {
+		var srcDir string
+
+		BeforeEach(func() {
+			var err error
+			srcDir, err = ioutil.TempDir("", "update-check-run")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(os.MkdirAll(path.Join(srcDir, "pr"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path.Join(srcDir, "pr", "pr_last_commit_hash"), []byte("fake-sha1"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(path.Join(srcDir, "pr", "pr_id"), []byte("fake-ref1"), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(srcDir)
+		})
+
+		It("should create a check run on the head commit", func() {
+			var body map[string]interface{}
+			mux.HandleFunc("/repos/fake-owner/fake-repo/commits/fake-sha1/check-runs", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.URL.Query().Get("check_name")).To(Equal("ci/unit"))
+				fmt.Fprint(w, `{"total_count":0,"check_runs":[]}`)
+			})
+			mux.HandleFunc("/repos/fake-owner/fake-repo/check-runs", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Method).To(Equal("POST"))
+				Expect(req.Header.Get("Accept")).To(ContainSubstring("antiope-preview"))
+				Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+				fmt.Fprint(w, `{"id":7,"name":"ci/unit"}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			ref, err := client.UpdateCheckRun(srcDir, r.CheckRun{
+				Name:       "ci/unit",
+				Status:     "completed",
+				Conclusion: "success",
+				Title:      "fake-title",
+				Summary:    "fake-summary",
+			}, "pr")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ref).To(Equal("fake-ref1"))
+			Expect(body).To(HaveKeyWithValue("name", "ci/unit"))
+			Expect(body).To(HaveKeyWithValue("head_sha", "fake-sha1"))
+			Expect(body).To(HaveKeyWithValue("status", "completed"))
+			Expect(body).To(HaveKeyWithValue("conclusion", "success"))
+			Expect(body).To(HaveKeyWithValue("output", map[string]interface{}{
+				"title":   "fake-title",
+				"summary": "fake-summary",
+			}))
+		})
+
+		It("should update an existing check run and send annotations in batches", func() {
+			var batches []int
+			mux.HandleFunc("/repos/fake-owner/fake-repo/commits/fake-sha1/check-runs", func(w http.ResponseWriter, req *http.Request) {
+				fmt.Fprint(w, `{"total_count":1,"check_runs":[{"id":7,"name":"ci/unit"}]}`)
+			})
+			mux.HandleFunc("/repos/fake-owner/fake-repo/check-runs/7", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Method).To(Equal("PATCH"))
+				var body struct {
+					Output struct {
+						Annotations []r.CheckRunAnnotation `json:"annotations"`
+					} `json:"output"`
+				}
+				Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+				batches = append(batches, len(body.Output.Annotations))
+				fmt.Fprint(w, `{"id":7,"name":"ci/unit"}`)
+			})
+
+			annotations := make([]r.CheckRunAnnotation, 60)
+			for i := range annotations {
+				annotations[i] = r.CheckRunAnnotation{Path: "main.go", StartLine: i + 1, EndLine: i + 1, AnnotationLevel: "warning", Message: "fake-message"}
+			}
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.UpdateCheckRun(srcDir, r.CheckRun{
+				Name:        "ci/unit",
+				Status:      "completed",
+				Conclusion:  "neutral",
+				Title:       "fake-title",
+				Summary:     "fake-summary",
+				Annotations: annotations,
+			}, "pr")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(batches).To(Equal([]int{50, 10}))
+		})
+
+		It("should return error for a completed run without conclusion", func() {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.UpdateCheckRun(srcDir, r.CheckRun{Name: "ci/unit", Status: "completed"}, "pr")
+			Expect(err).To(MatchError("a completed check run needs a conclusion"))
+		})
+
+		It("should return error for an invalid status", func() {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.UpdateCheckRun(srcDir, r.CheckRun{Name: "ci/unit", Status: "fake-status"}, "pr")
+			Expect(err).To(MatchError("fake-status is not a valid check run status"))
+		})
+	})
+
+	Describe("comments", func() {
+		It("should list the comments of every page", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/issues/1/comments", func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Query().Get("page") == "2" {
+					fmt.Fprint(w, `[{"id":2,"body":"second","user":{"login":"bob"}}]`)
+					return
+				}
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next"`, server.URL, req.URL.Path))
+				fmt.Fprint(w, `[{"id":1,"body":"first","user":{"login":"alice"},"created_at":"2018-06-01T12:30:00Z"}]`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			comments, err := client.ListComments(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(comments).To(HaveLen(2))
+			Expect(*comments[0]).To(Equal(r.Comment{
+				ID:        1,
+				Body:      "first",
+				Author:    "alice",
+				CreatedAt: time.Date(2018, 6, 1, 12, 30, 0, 0, time.UTC),
+			}))
+			Expect(comments[1].Author).To(Equal("bob"))
+		})
+
+		It("should create a comment", func() {
+			var body map[string]interface{}
+			mux.HandleFunc("/repos/fake-owner/fake-repo/issues/1/comments", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Method).To(Equal("POST"))
+				Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+				fmt.Fprint(w, `{"id":3,"body":"fake-body","html_url":"fake-url"}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			comment, err := client.CreateComment(1, "fake-body")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(Equal(map[string]interface{}{"body": "fake-body"}))
+			Expect(comment.ID).To(Equal(int64(3)))
+			Expect(comment.HTMLURL).To(Equal("fake-url"))
+		})
+
+		It("should edit a comment", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/issues/comments/3", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Method).To(Equal("PATCH"))
+				fmt.Fprint(w, `{"id":3,"body":"fake-body"}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			comment, err := client.EditComment(3, "fake-body")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(comment.Body).To(Equal("fake-body"))
+		})
+	})
+
+	Describe("labels", func() {
+		It("should add labels to the pull", func() {
+			var body []string
+			mux.HandleFunc("/repos/fake-owner/fake-repo/issues/1/labels", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Method).To(Equal("POST"))
+				Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+				fmt.Fprint(w, `[{"name":"ci-passed"}]`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.AddLabels(1, []string{"ci-passed"})).To(Succeed())
+			Expect(body).To(Equal([]string{"ci-passed"}))
+		})
+
+		It("should remove a label from the pull", func() {
+			var removed string
+			mux.HandleFunc("/repos/fake-owner/fake-repo/issues/1/labels/", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Method).To(Equal("DELETE"))
+				removed = strings.TrimPrefix(req.URL.Path, "/repos/fake-owner/fake-repo/issues/1/labels/")
+				fmt.Fprint(w, `[]`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.RemoveLabel(1, "needs rebase")).To(Succeed())
+			Expect(removed).To(Equal("needs rebase"))
+		})
+
+		It("should ignore a label the pull does not carry", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/issues/1/labels/", func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"message":"Label does not exist"}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.RemoveLabel(1, "needs-rebase")).To(Succeed())
+		})
+
+		It("should return error when removing fails", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/issues/1/labels/", func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"message":"fake-message"}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.RemoveLabel(1, "needs-rebase")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("removing label needs-rebase from pr 1"))
+		})
+	})
+
+	Describe("MergePR", func() {
+		It("should merge the pull and return the merge commit", func() {
+			var body map[string]interface{}
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls/1/merge", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Method).To(Equal("PUT"))
+				Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+				fmt.Fprint(w, `{"sha":"fake-merge-sha","merged":true}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			sha, err := client.MergePR(1, r.MergeRequest{Method: "rebase", SHA: "fake-sha1"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sha).To(Equal("fake-merge-sha"))
+			Expect(body).To(Equal(map[string]interface{}{"merge_method": "rebase", "sha": "fake-sha1"}))
+		})
+
+		It("should return error when the head sha does not match", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls/1/merge", func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprint(w, `{"message":"Head branch was modified. Review and try the merge again."}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.MergePR(1, r.MergeRequest{SHA: "fake-sha1"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Head branch was modified"))
+		})
+
+		It("should delete a branch", func() {
+			var deleted bool
+			mux.HandleFunc("/repos/fake-owner/fake-repo/git/refs/heads/fake-branch", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Method).To(Equal("DELETE"))
+				deleted = true
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.DeleteBranch("fake-branch")).To(Succeed())
+			Expect(deleted).To(BeTrue())
+		})
+	})
+
+	Describe("GetCommit", func() {
+		It("should get the commit details once per commit", func() {
+			requests := 0
+			mux.HandleFunc("/repos/fake-owner/fake-repo/git/commits/fake-sha1", func(w http.ResponseWriter, req *http.Request) {
+				requests++
+				fmt.Fprint(w, `{"sha":"fake-sha1","message":"fake-message [ci skip]","committer":{"date":"2018-06-01T12:30:00Z"}}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 2; i++ {
+				commit, err := client.GetCommit("fake-sha1")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(*commit).To(Equal(r.Commit{SHA: "fake-sha1", Message: "fake-message [ci skip]", CommittedAt: time.Date(2018, 6, 1, 12, 30, 0, 0, time.UTC)}))
+			}
+			Expect(requests).To(Equal(1))
+		})
+	})
+
+	Describe("Login", func() {
+		It("should get the authenticated user once", func() {
+			requests := 0
+			mux.HandleFunc("/user", func(w http.ResponseWriter, req *http.Request) {
+				requests++
+				fmt.Fprint(w, `{"login":"ci-bot"}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 2; i++ {
+				login, err := client.Login()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(login).To(Equal("ci-bot"))
+			}
+			Expect(requests).To(Equal(1))
+		})
+	})
+
+	Describe("LabeledSincePush", func() {
+		labeledSincePush := func(timeline ...string) bool {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/issues/1/timeline", func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Query().Get("page") == "2" {
+					fmt.Fprintf(w, "[%s]", strings.Join(timeline[1:], ","))
+					return
+				}
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next"`, server.URL, req.URL.Path))
+				fmt.Fprintf(w, "[%s]", timeline[0])
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			labeled, err := client.LabeledSincePush(1, "ok-to-test")
+			Expect(err).ToNot(HaveOccurred())
+			return labeled
+		}
+
+		It("should be true when the label was added after the last push", func() {
+			Expect(labeledSincePush(
+				`{"event":"committed","sha":"fake-sha1"}`,
+				`{"event":"labeled","label":{"name":"ok-to-test"}}`,
+				`{"event":"commented"}`,
+			)).To(BeTrue())
+		})
+
+		It("should be false when commits were pushed after the label", func() {
+			Expect(labeledSincePush(
+				`{"event":"labeled","label":{"name":"ok-to-test"}}`,
+				`{"event":"committed","sha":"fake-sha2"}`,
+			)).To(BeFalse())
+		})
+
+		It("should be false when the pull was force pushed after the label", func() {
+			Expect(labeledSincePush(
+				`{"event":"labeled","label":{"name":"ok-to-test"}}`,
+				`{"event":"head_ref_force_pushed"}`,
+			)).To(BeFalse())
+		})
+
+		It("should be false when the label was removed", func() {
+			Expect(labeledSincePush(
+				`{"event":"labeled","label":{"name":"ok-to-test"}}`,
+				`{"event":"unlabeled","label":{"name":"ok-to-test"}}`,
+				`{"event":"labeled","label":{"name":"wip"}}`,
+			)).To(BeFalse())
+		})
+	})
+
+	Describe("LastForcePush", func() {
+		It("should return the latest force push of every page", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/issues/1/events", func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Query().Get("page") == "2" {
+					fmt.Fprint(w, `[{"event":"head_ref_force_pushed","created_at":"2018-06-01T13:00:00Z"},{"event":"labeled","created_at":"2018-06-01T14:00:00Z"}]`)
+					return
+				}
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next"`, server.URL, req.URL.Path))
+				fmt.Fprint(w, `[{"event":"head_ref_force_pushed","created_at":"2018-06-01T12:00:00Z"}]`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			forcePushedAt, err := client.LastForcePush(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(forcePushedAt).To(Equal(time.Date(2018, 6, 1, 13, 0, 0, 0, time.UTC)))
+		})
+
+		It("should return zero for a pr that was never force pushed", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/issues/1/events", func(w http.ResponseWriter, req *http.Request) {
+				fmt.Fprint(w, `[{"event":"labeled","created_at":"2018-06-01T14:00:00Z"}]`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			forcePushedAt, err := client.LastForcePush(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(forcePushedAt.IsZero()).To(BeTrue())
+		})
+	})
+
+	Describe("retries", func() {
+		var requests int
+		var responses []func(w http.ResponseWriter)
+
+		BeforeEach(func() {
+			requests = 0
+			responses = nil
+			mux.HandleFunc("/repos/fake-owner/fake-repo/collaborators/alice/permission", func(w http.ResponseWriter, req *http.Request) {
+				requests++
+				if len(responses) > 0 {
+					respond := responses[0]
+					responses = responses[1:]
+					respond(w)
+					return
+				}
+				fmt.Fprint(w, `{"permission":"write"}`)
+			})
+		})
+
+		rateLimited := func(reset time.Time) func(w http.ResponseWriter) {
+			return func(w http.ResponseWriter) {
+				w.Header().Set("X-RateLimit-Limit", "5000")
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"message":"API rate limit exceeded"}`)
+			}
+		}
+
+		getPermission := func() (string, error) {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+			return client.GetPermission("alice")
+		}
+
+		It("should wait for the rate limit to reset", func() {
+			responses = append(responses, rateLimited(time.Now()))
+
+			permission, err := getPermission()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(permission).To(Equal("write"))
+			Expect(requests).To(Equal(2))
+		})
+
+		It("should wait as long as Retry-After says", func() {
+			responses = append(responses, func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"message":"You have triggered an abuse detection mechanism"}`)
+			})
+
+			start := time.Now()
+			permission, err := getPermission()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(permission).To(Equal("write"))
+			Expect(requests).To(Equal(2))
+			Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+		})
+
+		It("should back off on server errors", func() {
+			responses = append(responses, func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusBadGateway)
+			})
+
+			permission, err := getPermission()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(permission).To(Equal("write"))
+			Expect(requests).To(Equal(2))
+		})
+
+		It("should not wait longer than max_retry_wait", func() {
+			source.MaxRetryWait = "1m"
+			responses = append(responses, rateLimited(time.Now().Add(time.Hour)))
+
+			start := time.Now()
+			_, err := getPermission()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("API rate limit exceeded"))
+			Expect(requests).To(Equal(1))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		It("should not repeat requests that may have been processed", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/issues/1/comments", func(w http.ResponseWriter, req *http.Request) {
+				requests++
+				w.WriteHeader(http.StatusBadGateway)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.CreateComment(1, "fake-comment")
+			Expect(err).To(HaveOccurred())
+			Expect(requests).To(Equal(1))
+		})
+
+		It("should warn when the quota runs low", func() {
+			output := &bytes.Buffer{}
+			log.SetOutput(output)
+			defer log.SetOutput(os.Stderr)
+
+			responses = append(responses, func(w http.ResponseWriter) {
+				w.Header().Set("X-RateLimit-Limit", "5000")
+				w.Header().Set("X-RateLimit-Remaining", "42")
+				w.Header().Set("X-RateLimit-Reset", "1528070400")
+				fmt.Fprint(w, `{"permission":"write"}`)
+			})
+
+			_, err := getPermission()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output.String()).To(ContainSubstring("42 of 5000 github api requests left until the rate limit resets at 2018-06-04T00:00:00Z"))
+		})
+
+		It("should return error for an invalid max_retry_wait", func() {
+			source.MaxRetryWait = "forever"
+			_, err := r.NewGithubClient(source)
+			Expect(err).To(MatchError("forever is not a valid max_retry_wait"))
+		})
+	})
+
+	Describe("cache", func() {
+		var cacheDir string
+		var etag string
+		var conditional []string
+
+		BeforeEach(func() {
+			var err error
+			cacheDir, err = ioutil.TempDir("", "github-cache")
+			Expect(err).ToNot(HaveOccurred())
+			source.CacheDir = cacheDir
+
+			etag = `"v1"`
+			conditional = []string{}
+			mux.HandleFunc("/repos/fake-owner/fake-repo/pulls", func(w http.ResponseWriter, req *http.Request) {
+				conditional = append(conditional, req.Header.Get("If-None-Match"))
+				w.Header().Set("X-RateLimit-Remaining", "4999")
+				if req.Header.Get("If-None-Match") == etag {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("ETag", etag)
+				fmt.Fprintf(w, `[{"number":1,"state":"open","head":{"sha":"fake-sha"},"title":%s}]`, etag)
+			})
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(cacheDir)
+		})
+
+		listTitles := func() []string {
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			pulls, err := client.ListPRs()
+			Expect(err).ToNot(HaveOccurred())
+
+			titles := []string{}
+			for _, pull := range pulls {
+				titles = append(titles, pull.Title)
+			}
+			return titles
+		}
+
+		It("should answer unchanged requests from the cache", func() {
+			Expect(listTitles()).To(Equal([]string{"v1"}))
+			Expect(listTitles()).To(Equal([]string{"v1"}))
+			Expect(conditional).To(Equal([]string{"", `"v1"`}))
+		})
+
+		It("should replace changed responses", func() {
+			Expect(listTitles()).To(Equal([]string{"v1"}))
+			etag = `"v2"`
+			Expect(listTitles()).To(Equal([]string{"v2"}))
+			Expect(listTitles()).To(Equal([]string{"v2"}))
+			Expect(conditional).To(Equal([]string{"", `"v1"`, `"v2"`}))
+		})
+
+		It("should keep responses apart by token", func() {
+			source.AccessToken = "fake-token1"
+			Expect(listTitles()).To(Equal([]string{"v1"}))
+			source.AccessToken = "fake-token2"
+			Expect(listTitles()).To(Equal([]string{"v1"}))
+			Expect(conditional).To(Equal([]string{"", ""}))
+		})
+
+		It("should not cache with disable_cache", func() {
+			source.DisableCache = true
+			Expect(listTitles()).To(Equal([]string{"v1"}))
+			Expect(listTitles()).To(Equal([]string{"v1"}))
+			Expect(conditional).To(Equal([]string{"", ""}))
+
+			files, err := ioutil.ReadDir(cacheDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(BeEmpty())
+		})
+
+		age := func(file string, age time.Duration) {
+			then := time.Now().Add(-age)
+			Expect(os.Chtimes(file, then, then)).To(Succeed())
+		}
+
+		cachedFiles := func() []os.FileInfo {
+			files, err := ioutil.ReadDir(cacheDir)
+			Expect(err).ToNot(HaveOccurred())
+			return files
+		}
+
+		It("should remove responses not used for a week", func() {
+			Expect(listTitles()).To(Equal([]string{"v1"}))
+			Expect(cachedFiles()).To(HaveLen(1))
+			age(path.Join(cacheDir, cachedFiles()[0].Name()), 8*24*time.Hour)
+
+			Expect(listTitles()).To(Equal([]string{"v1"}))
+			Expect(conditional).To(Equal([]string{"", ""}))
+			Expect(cachedFiles()).To(HaveLen(1))
+		})
+
+		It("should keep responses that are still used", func() {
+			Expect(listTitles()).To(Equal([]string{"v1"}))
+			file := path.Join(cacheDir, cachedFiles()[0].Name())
+			age(file, 6*24*time.Hour)
+
+			Expect(listTitles()).To(Equal([]string{"v1"}))
+			Expect(conditional).To(Equal([]string{"", `"v1"`}))
+
+			info, err := os.Stat(file)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.ModTime()).To(BeTemporally("~", time.Now(), time.Minute))
+		})
+
+		It("should be shared safely by concurrent clients", func() {
+			for i := 0; i < 20; i++ {
+				stale := path.Join(cacheDir, fmt.Sprintf("fake-stale%d", i))
+				Expect(ioutil.WriteFile(stale, []byte("fake-response"), 0600)).To(Succeed())
+				age(stale, 8*24*time.Hour)
+			}
+
+			var mu sync.Mutex
+			handler := server.Config.Handler
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				handler.ServeHTTP(w, req)
+			})
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					Expect(listTitles()).To(Equal([]string{"v1"}))
+				}()
+			}
+			wg.Wait()
+
+			Expect(cachedFiles()).To(HaveLen(1))
+		})
+	})
+
+	Describe("app authentication", func() {
+		var key *rsa.PrivateKey
+		var exchanges int
+
+		BeforeEach(func() {
+			var err error
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).ToNot(HaveOccurred())
+
+			source.AppID = 7
+			source.InstallationID = 42
+			source.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+
+			exchanges = 0
+			mux.HandleFunc("/app/installations/42/access_tokens", func(w http.ResponseWriter, req *http.Request) {
+				defer GinkgoRecover()
+				exchanges++
+				Expect(req.Method).To(Equal("POST"))
+
+				authorization := strings.Fields(req.Header.Get("Authorization"))
+				Expect(authorization).To(HaveLen(2))
+				Expect(authorization[0]).To(Equal("Bearer"))
+
+				parts := strings.Split(authorization[1], ".")
+				Expect(parts).To(HaveLen(3))
+
+				signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+				Expect(err).ToNot(HaveOccurred())
+				digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+				Expect(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature)).To(Succeed())
+
+				header, err := base64.RawURLEncoding.DecodeString(parts[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(header).To(MatchJSON(`{"alg":"RS256","typ":"JWT"}`))
+
+				payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+				Expect(err).ToNot(HaveOccurred())
+				var claims struct {
+					Iss int64 `json:"iss"`
+					Iat int64 `json:"iat"`
+					Exp int64 `json:"exp"`
+				}
+				Expect(json.Unmarshal(payload, &claims)).To(Succeed())
+				Expect(claims.Iss).To(Equal(int64(7)))
+				Expect(claims.Iat).To(BeNumerically("<", time.Now().Unix()))
+				Expect(claims.Exp).To(BeNumerically(">", time.Now().Unix()))
+				Expect(claims.Exp - claims.Iat).To(BeNumerically("<=", 600))
+
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"token":"fake-installation-token","expires_at":"2018-06-01T13:00:00Z"}`)
+			})
+		})
+
+		It("should use the installation token for the API", func() {
+			mux.HandleFunc("/repos/fake-owner/fake-repo/collaborators/alice/permission", func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Header.Get("Authorization")).To(Equal("Bearer fake-installation-token"))
+				fmt.Fprint(w, `{"permission":"write"}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exchanges).To(Equal(1))
+
+			permission, err := client.GetPermission("alice")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(permission).To(Equal("write"))
+		})
+
+		It("should comment as the bot user of the app", func() {
+			mux.HandleFunc("/app", func(w http.ResponseWriter, req *http.Request) {
+				defer GinkgoRecover()
+				Expect(strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ")).To(BeTrue())
+				Expect(req.Header.Get("Authorization")).ToNot(Equal("Bearer fake-installation-token"))
+				fmt.Fprint(w, `{"id":7,"slug":"fake-ci"}`)
+			})
+
+			client, err := r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			login, err := client.Login()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(login).To(Equal("fake-ci[bot]"))
+		})
+
+		It("should accept PKCS8 keys", func() {
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			Expect(err).ToNot(HaveOccurred())
+			source.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+			_, err = r.NewGithubClient(source)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exchanges).To(Equal(1))
+		})
+
+		It("should return error when the exchange fails", func() {
+			source.InstallationID = 43
+			mux.HandleFunc("/app/installations/43/access_tokens", func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"message":"A JSON web token could not be decoded"}`)
+			})
+
+			_, err := r.NewGithubClient(source)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("getting token of installation 43:"))
+		})
+
+		It("should return error for an invalid private_key", func() {
+			source.PrivateKey = "fake-key"
+			_, err := r.NewGithubClient(source)
+			Expect(err).To(MatchError("parsing private_key: no PEM encoded key found"))
+		})
+
+		It("should return error for an incomplete app config", func() {
+			source.InstallationID = 0
+			_, err := r.NewGithubClient(source)
+			Expect(err).To(MatchError("app_id, installation_id and private_key must be set together"))
+		})
+
+		It("should return error when an access_token is set as well", func() {
+			source.AccessToken = "fake-token"
+			_, err := r.NewGithubClient(source)
+			Expect(err).To(MatchError("access_token cannot be set together with app_id"))
+		})
+	})
+})
+
+var _ = Describe("GraphQLClient", func() {
+	var server *httptest.Server
+	var mux *http.ServeMux
+	var source r.Source
+	var requests []map[string]interface{}
+	var pages map[string]string
+
+	BeforeEach(func() {
+		mux = http.NewServeMux()
+		server = httptest.NewServer(mux)
+		source = r.Source{
+			Owner:   "fake-owner",
+			Repo:    "fake-repo",
+			APIURL:  server.URL,
+			APIMode: "graphql",
+		}
+
+		requests = []map[string]interface{}{}
+		pages = map[string]string{}
+		mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+			Expect(req.Method).To(Equal("POST"))
+
+			var body struct {
+				Query     string                 `json:"query"`
+				Variables map[string]interface{} `json:"variables"`
+			}
+			Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+			Expect(body.Query).To(ContainSubstring("pullRequests("))
+			requests = append(requests, body.Variables)
+
+			after, _ := body.Variables["after"].(string)
+			fmt.Fprint(w, pages[after])
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	page := func(hasNext bool, cursor string, nodes ...string) string {
+		return fmt.Sprintf(`{"data":{"repository":{"pullRequests":{"pageInfo":{"hasNextPage":%t,"endCursor":%q},"nodes":[%s]}}}}`,
+			hasNext, cursor, strings.Join(nodes, ","))
+	}
+
+	node := func(number int, updatedAt string, extra string) string {
+		return fmt.Sprintf(`{"number":%d,"state":"OPEN","updatedAt":%q,"headRefOid":"fake-sha%d",
+			"baseRepository":{"nameWithOwner":"fake-owner/fake-repo"},
+			"headRepository":{"nameWithOwner":"fake-owner/fake-repo"},
+			"files":{"totalCount":0,"nodes":[]},"reviews":{"totalCount":0,"nodes":[]}%s}`, number, updatedAt, number, extra)
+	}
+
+	client := func() r.Github {
+		client, err := r.NewGithub(source)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client).To(BeAssignableToTypeOf(&r.GraphQLClient{}))
+		return client
+	}
+
+	It("should reject an unknown api_mode", func() {
+		source.APIMode = "soap"
+		_, err := r.NewGithub(source)
+		Expect(err).To(MatchError("soap is not a valid api_mode"))
+	})
+
+	It("should list pulls page by page in ascending update time", func() {
+		pages[""] = page(true, "cursor1", node(2, "2018-06-01T12:00:00Z", ""))
+		pages["cursor1"] = page(false, "", node(1, "2018-06-01T11:00:00Z", ""))
+		source.States = []string{"open", "merged"}
+
+		pulls, err := client().ListPRs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pulls).To(HaveLen(2))
+		Expect(pulls[0].Number).To(Equal(1))
+		Expect(pulls[1].Number).To(Equal(2))
+
+		Expect(requests).To(HaveLen(2))
+		Expect(requests[0]["owner"]).To(Equal("fake-owner"))
+		Expect(requests[0]["repo"]).To(Equal("fake-repo"))
+		Expect(requests[0]["states"]).To(Equal([]interface{}{"OPEN", "MERGED"}))
+		Expect(requests[0]["first"]).To(BeNumerically("==", 100))
+		Expect(requests[0]).ToNot(HaveKey("after"))
+		Expect(requests[1]["after"]).To(Equal("cursor1"))
+	})
+
+	It("should list pulls that moved to a later page while paging once", func() {
+		pages[""] = page(true, "cursor1", node(3, "2018-06-01T13:00:00Z", ""), node(2, "2018-06-01T12:00:00Z", ""))
+		pages["cursor1"] = page(false, "", node(2, "2018-06-01T12:00:00Z", ""), node(1, "2018-06-01T11:00:00Z", ""))
+
+		pulls, err := client().ListPRs()
+		Expect(err).ToNot(HaveOccurred())
+
+		numbers := []int{}
+		for _, pull := range pulls {
+			numbers = append(numbers, pull.Number)
+		}
+		Expect(numbers).To(Equal([]int{1, 2, 3}))
+	})
+
+	It("should convert the fields of pulls", func() {
+		pages[""] = page(false, "", `{"number":1,"title":"fake-title","body":"fake-body",
+			"url":"https://github.com/fake-owner/fake-repo/pull/1","createdAt":"2018-06-01T10:00:00Z","updatedAt":"2018-06-01T12:00:00Z",
+			"isDraft":true,"mergeable":"CONFLICTING","state":"MERGED","author":{"login":"alice"},
+			"baseRefName":"master","baseRefOid":"fake-base","headRefName":"feature","headRefOid":"fake-head",
+			"baseRepository":{"nameWithOwner":"fake-owner/fake-repo"},"headRepository":{"nameWithOwner":"alice/fake-repo"},
+			"mergeCommit":{"oid":"fake-merge"},"labels":{"nodes":[{"name":"bug"}]},
+			"files":{"totalCount":0,"nodes":[]},"reviews":{"totalCount":0,"nodes":[]}}`)
+
+		pulls, err := client().ListPRs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pulls).To(HaveLen(1))
+
+		mergeable := false
+		Expect(*pulls[0]).To(Equal(r.Pull{
+			Number:          1,
+			Ref:             "fake-head",
+			LatestCommitSHA: "fake-head",
+			URL:             server.URL + "/repos/fake-owner/fake-repo/pulls/1",
+			Title:           "fake-title",
+			Body:            "fake-body",
+			Labels:          []string{"bug"},
+			CreatedAt:       time.Date(2018, 6, 1, 10, 0, 0, 0, time.UTC),
+			UpdatedAt:       time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC),
+			BaseRef:         "master",
+			BaseSHA:         "fake-base",
+			Draft:           true,
+			Mergeable:       &mergeable,
+			Author:          "alice",
+			HTMLURL:         "https://github.com/fake-owner/fake-repo/pull/1",
+			HeadRef:         "feature",
+			HeadRepo:        "alice/fake-repo",
+			Fork:            true,
+			State:           "merged",
+			MergeCommitSHA:  "fake-merge",
+		}))
+	})
+
+	It("should serve files, reviews, head commits and their status from the listing", func() {
+		pages[""] = page(false, "", node(1, "2018-06-01T12:00:00Z", `,
+			"files":{"totalCount":2,"nodes":[{"path":"README.md"},{"path":"docs/index.md"}]},
+			"reviews":{"totalCount":1,"nodes":[{"databaseId":80,"state":"APPROVED","submittedAt":"2018-06-01T13:00:00Z",
+				"authorAssociation":"MEMBER","author":{"login":"bob"},"commit":{"oid":"fake-sha1"}}]},
+			"commits":{"nodes":[{"commit":{"oid":"fake-sha1","message":"Fix docs [ci skip]","committedDate":"2018-06-01T11:00:00Z",
+				"status":{"state":"PENDING"}}}]}`))
+
+		github := client()
+		pulls, err := github.ListPRs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pulls[0].Status).To(Equal("pending"))
+
+		files, err := github.ListChangedFiles(1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(Equal([]string{"README.md", "docs/index.md"}))
+
+		reviews, err := github.ListReviews(1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reviews).To(Equal([]*r.Review{{
+			ID:                80,
+			Author:            "bob",
+			AuthorAssociation: "MEMBER",
+			State:             "APPROVED",
+			CommitID:          "fake-sha1",
+			SubmittedAt:       time.Date(2018, 6, 1, 13, 0, 0, 0, time.UTC),
+		}}))
+
+		commit, err := github.GetCommit("fake-sha1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*commit).To(Equal(r.Commit{SHA: "fake-sha1", Message: "Fix docs [ci skip]", CommittedAt: time.Date(2018, 6, 1, 11, 0, 0, 0, time.UTC)}))
+
+		Expect(requests).To(HaveLen(1))
+	})
+
+	It("should serve the last force push from the listing", func() {
+		pages[""] = page(false, "", node(1, "2018-06-01T12:00:00Z", `,
+			"timelineItems":{"nodes":[{"createdAt":"2018-06-01T11:00:00Z"}]}`), node(2, "2018-06-01T12:00:00Z", `,
+			"timelineItems":{"nodes":[]}`))
+
+		github := client()
+		_, err := github.ListPRs()
+		Expect(err).ToNot(HaveOccurred())
+
+		forcePushedAt, err := github.LastForcePush(1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(forcePushedAt).To(Equal(time.Date(2018, 6, 1, 11, 0, 0, 0, time.UTC)))
+
+		forcePushedAt, err = github.LastForcePush(2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(forcePushedAt.IsZero()).To(BeTrue())
+
+		Expect(requests).To(HaveLen(1))
+	})
+
+	It("should fall back to the REST API for pulls with more files than fetched", func() {
+		pages[""] = page(false, "", node(1, "2018-06-01T12:00:00Z", `,
+			"files":{"totalCount":101,"nodes":[{"path":"README.md"}]}`))
+		mux.HandleFunc("/repos/fake-owner/fake-repo/pulls/1/files", func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(w, `[{"filename":"README.md"},{"filename":"main.go"}]`)
+		})
+
+		github := client()
+		_, err := github.ListPRs()
+		Expect(err).ToNot(HaveOccurred())
+
+		files, err := github.ListChangedFiles(1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(Equal([]string{"README.md", "main.go"}))
+	})
+
+	It("should return the errors of the query", func() {
+		pages[""] = `{"data":null,"errors":[{"message":"Field 'isDraft' doesn't exist"},{"message":"rate limited"}]}`
+
+		_, err := client().ListPRs()
+		Expect(err).To(MatchError("listing pr: graphql: Field 'isDraft' doesn't exist, rate limited"))
+	})
+
+	It("should return error for a missing repository", func() {
+		pages[""] = `{"data":{"repository":null}}`
+
+		_, err := client().ListPRs()
+		Expect(err).To(MatchError("listing pr: repository fake-owner/fake-repo not found"))
+	})
+})
+
+func runGit(dir string, args ...string) string {
+	args = append([]string{
+		"-c", "user.name=fake-user",
+		"-c", "user.email=fake@example.com",
+		"-c", "protocol.file.allow=always",
+	}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	ExpectWithOffset(1, err).ToNot(HaveOccurred(), string(output))
+	return strings.TrimSpace(string(output))
+}
+
+func commitFile(dir, name, content string) {
+	ExpectWithOffset(1, ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644)).To(Succeed())
+	runGit(dir, "add", ".")
+	runGit(dir, "commit", "-q", "-m", "add "+name)
+}
+
+// createBareRepo creates a bare repository below dir with a master branch and
+// a pull request head at refs/pull/1/head, it returns the bare repository path
+// and the pull request head sha.
+//
+// The pull request branches off the first master commit, adds three commits
+// and a submodule "sub" which itself has a submodule "nested", its merge into
+// master is at refs/pull/1/merge. Master moves on by two commits after the
+// branch point, one of them changing README.md. A second pull request at
+// refs/pull/2/head changes README.md as well and conflicts with master.
+func createBareRepo(dir string) (string, string) {
+	nestedDir := createRepo(dir, "nested", func(workDir string) {
+		commitFile(workDir, "nested.txt", "nested\n")
+	})
+	subDir := createRepo(dir, "sub", func(workDir string) {
+		commitFile(workDir, "sub.txt", "sub\n")
+		runGit(workDir, "submodule", "add", "-q", nestedDir, "nested")
+		runGit(workDir, "commit", "-q", "-m", "add nested")
+	})
+
+	var prSHA, conflictSHA, mergeSHA string
+	bareDir := createRepo(dir, "bare", func(workDir string) {
+		commitFile(workDir, "README.md", "readme\n")
+
+		runGit(workDir, "checkout", "-q", "-b", "feature")
+		runGit(workDir, "submodule", "add", "-q", subDir, "sub")
+		runGit(workDir, "commit", "-q", "-m", "add sub")
+		commitFile(workDir, "other.txt", "other\n")
+		commitFile(workDir, "feature.txt", "feature\n")
+		prSHA = runGit(workDir, "rev-parse", "HEAD")
+
+		runGit(workDir, "checkout", "-q", "-b", "conflict", "master")
+		commitFile(workDir, "README.md", "readme\nconflict\n")
+		conflictSHA = runGit(workDir, "rev-parse", "HEAD")
+
+		runGit(workDir, "checkout", "-q", "master")
+		commitFile(workDir, "master1.txt", "master1\n")
+		commitFile(workDir, "README.md", "readme\nmaster\n")
+
+		runGit(workDir, "checkout", "-q", "-b", "merge", "master")
+		runGit(workDir, "merge", "-q", "--no-ff", "-m", "merge feature", "feature")
+		mergeSHA = runGit(workDir, "rev-parse", "HEAD")
+		runGit(workDir, "checkout", "-q", "master")
+	})
+
+	runGit(bareDir, "update-ref", "refs/pull/1/head", prSHA)
+	runGit(bareDir, "update-ref", "refs/pull/1/merge", mergeSHA)
+	runGit(bareDir, "update-ref", "refs/pull/2/head", conflictSHA)
+	for _, branch := range []string{"feature", "conflict", "merge"} {
+		runGit(bareDir, "branch", "-D", branch)
+	}
+
+	return bareDir, prSHA
+}
+
+// createRepo initialises a repository on master below dir, lets populate add
+// commits to it and returns the path of a bare clone.
+func createRepo(dir, name string, populate func(string)) string {
+	workDir := path.Join(dir, name+"-work")
+	bareDir := path.Join(dir, name+".git")
+	ExpectWithOffset(1, os.MkdirAll(workDir, 0755)).To(Succeed())
+
+	runGit(workDir, "init", "-q")
+	runGit(workDir, "checkout", "-q", "-b", "master")
+	populate(workDir)
+
+	runGit(dir, "clone", "-q", "--bare", workDir, bareDir)
+	return bareDir
+}
+
+func getPull(client *r.GithubClient, number int) *r.Pull {
+	pull, err := client.GetPR(number)
+	Expect(err).ToNot(HaveOccurred())
+	return pull
+}
@@ -0,0 +1,407 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The APIs a Github can be backed by, see Source.APIMode.
+const (
+	apiModeREST    = "rest"
+	apiModeGraphQL = "graphql"
+)
+
+// pullsQuery lists the pulls of a repository, newest first, with everything
+// check filters on, so filtering them does not take a request per pull.
+const pullsQuery = `query($owner: String!, $repo: String!, $states: [PullRequestState!], $first: Int!, $after: String) {
+  repository(owner: $owner, name: $repo) {
+    pullRequests(states: $states, first: $first, after: $after, orderBy: {field: UPDATED_AT, direction: DESC}) {
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        number title body url createdAt updatedAt isDraft mergeable state
+        author { login }
+        baseRefName baseRefOid headRefName headRefOid
+        baseRepository { nameWithOwner }
+        headRepository { nameWithOwner }
+        mergeCommit { oid }
+        labels(first: 100) { nodes { name } }
+        files(first: 100) { totalCount nodes { path } }
+        reviews(first: 100) {
+          totalCount
+          nodes { databaseId state submittedAt authorAssociation author { login } commit { oid } }
+        }
+        commits(last: 1) { nodes { commit { oid message committedDate status { state } } } }
+        timelineItems(last: 1, itemTypes: [HEAD_REF_FORCE_PUSHED_EVENT]) {
+          nodes { ... on HeadRefForcePushedEvent { createdAt } }
+        }
+      }
+    }
+  }
+}`
+
+// NewGithub returns the Github for the api_mode of source.
+func NewGithub(source Source) (Github, error) {
+	switch source.APIMode {
+	case "", apiModeREST:
+		return NewGithubClient(source)
+	case apiModeGraphQL:
+		return NewGraphQLClient(source)
+	default:
+		return nil, fmt.Errorf("%s is not a valid api_mode", source.APIMode)
+	}
+}
+
+// GraphQLClient is a Github listing pulls with a single paginated query of
+// the GraphQL API. The changed files, reviews, head commits and force pushes
+// of the pulls come along, anything else goes through the REST API. Listed
+// pulls carry the combined status of their head commit, too.
+type GraphQLClient struct {
+	*GithubClient
+	endpoint string
+
+	// files and reviews hold what ListPRs fetched, for pulls that did not
+	// have more of them than fit into the query.
+	files   map[int][]string
+	reviews map[int][]*Review
+
+	// forcePushes holds the time every listed pull was last force pushed.
+	forcePushes map[int]time.Time
+}
+
+// NewGraphQLClient is
+func NewGraphQLClient(source Source) (*GraphQLClient, error) {
+	gc, err := NewGithubClient(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GraphQLClient{
+		GithubClient: gc,
+		endpoint:     graphQLEndpoint(gc.client.BaseURL.String()),
+		files:        map[int][]string{},
+		reviews:      map[int][]*Review{},
+		forcePushes:  map[int]time.Time{},
+	}, nil
+}
+
+// graphQLEndpoint derives the GraphQL endpoint from the REST one, GitHub
+// Enterprise serves it next to the REST API rather than below it.
+func graphQLEndpoint(baseURL string) string {
+	if strings.HasSuffix(baseURL, "/api/v3/") {
+		return strings.TrimSuffix(baseURL, "v3/") + "graphql"
+	}
+	return baseURL + "graphql"
+}
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+type pullsData struct {
+	Repository *struct {
+		PullRequests struct {
+			PageInfo struct {
+				HasNextPage bool   `json:"hasNextPage"`
+				EndCursor   string `json:"endCursor"`
+			} `json:"pageInfo"`
+			Nodes []*graphQLPull `json:"nodes"`
+		} `json:"pullRequests"`
+	} `json:"repository"`
+}
+
+type graphQLLogin struct {
+	Login string `json:"login"`
+}
+
+type graphQLRepo struct {
+	NameWithOwner string `json:"nameWithOwner"`
+}
+
+type graphQLPull struct {
+	Number         int           `json:"number"`
+	Title          string        `json:"title"`
+	Body           string        `json:"body"`
+	URL            string        `json:"url"`
+	CreatedAt      time.Time     `json:"createdAt"`
+	UpdatedAt      time.Time     `json:"updatedAt"`
+	IsDraft        bool          `json:"isDraft"`
+	Mergeable      string        `json:"mergeable"`
+	State          string        `json:"state"`
+	Author         *graphQLLogin `json:"author"`
+	BaseRefName    string        `json:"baseRefName"`
+	BaseRefOid     string        `json:"baseRefOid"`
+	HeadRefName    string        `json:"headRefName"`
+	HeadRefOid     string        `json:"headRefOid"`
+	BaseRepository *graphQLRepo  `json:"baseRepository"`
+	HeadRepository *graphQLRepo  `json:"headRepository"`
+	MergeCommit    *struct {
+		Oid string `json:"oid"`
+	} `json:"mergeCommit"`
+	Labels struct {
+		Nodes []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	} `json:"labels"`
+	Files struct {
+		TotalCount int `json:"totalCount"`
+		Nodes      []struct {
+			Path string `json:"path"`
+		} `json:"nodes"`
+	} `json:"files"`
+	Reviews struct {
+		TotalCount int `json:"totalCount"`
+		Nodes      []struct {
+			DatabaseID        int64         `json:"databaseId"`
+			State             string        `json:"state"`
+			SubmittedAt       time.Time     `json:"submittedAt"`
+			AuthorAssociation string        `json:"authorAssociation"`
+			Author            *graphQLLogin `json:"author"`
+			Commit            *struct {
+				Oid string `json:"oid"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"reviews"`
+	Commits struct {
+		Nodes []struct {
+			Commit struct {
+				Oid           string    `json:"oid"`
+				Message       string    `json:"message"`
+				CommittedDate time.Time `json:"committedDate"`
+				Status        *struct {
+					State string `json:"state"`
+				} `json:"status"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"commits"`
+	TimelineItems struct {
+		Nodes []struct {
+			CreatedAt time.Time `json:"createdAt"`
+		} `json:"nodes"`
+	} `json:"timelineItems"`
+}
+
+// ListPRs is
+func (qc *GraphQLClient) ListPRs() ([]*Pull, error) {
+	states := []string{}
+	for _, state := range []string{pullStateOpen, pullStateClosed, pullStateMerged} {
+		if qc.states[state] {
+			states = append(states, strings.ToUpper(state))
+		}
+	}
+
+	variables := map[string]interface{}{
+		"owner":  qc.owner,
+		"repo":   qc.repo,
+		"states": states,
+		"first":  qc.perPage,
+	}
+
+	seen := map[int]bool{}
+	var convertedPulls = []*Pull{}
+	for {
+		var data pullsData
+		if err := qc.query(pullsQuery, variables, &data); err != nil {
+			return nil, fmt.Errorf("listing pr: %+v", err)
+		}
+
+		if data.Repository == nil {
+			return nil, fmt.Errorf("listing pr: repository %s/%s not found", qc.owner, qc.repo)
+		}
+
+		page := data.Repository.PullRequests
+		for _, pull := range page.Nodes {
+			if seen[pull.Number] {
+				continue
+			}
+			seen[pull.Number] = true
+
+			convertedPulls = append(convertedPulls, qc.convertPull(pull))
+			if len(convertedPulls) >= qc.maxPRs {
+				log.Warnf("reached max_prs limit of %d, ignoring older pull requests", qc.maxPRs)
+				return sortPulls(convertedPulls), nil
+			}
+		}
+
+		if !page.PageInfo.HasNextPage {
+			break
+		}
+		variables["after"] = page.PageInfo.EndCursor
+	}
+
+	return sortPulls(convertedPulls), nil
+}
+
+// ListChangedFiles returns the files ListPRs fetched, it only asks the API
+// for pulls with more files than fit into the query.
+func (qc *GraphQLClient) ListChangedFiles(number int) ([]string, error) {
+	if files, ok := qc.files[number]; ok {
+		return files, nil
+	}
+	return qc.GithubClient.ListChangedFiles(number)
+}
+
+// ListReviews returns the reviews ListPRs fetched, it only asks the API for
+// pulls with more reviews than fit into the query.
+func (qc *GraphQLClient) ListReviews(number int) ([]*Review, error) {
+	if reviews, ok := qc.reviews[number]; ok {
+		return reviews, nil
+	}
+	return qc.GithubClient.ListReviews(number)
+}
+
+// LastForcePush returns the force push ListPRs fetched, it only asks the API
+// for pulls ListPRs did not list.
+func (qc *GraphQLClient) LastForcePush(number int) (time.Time, error) {
+	if forcePushedAt, ok := qc.forcePushes[number]; ok {
+		return forcePushedAt, nil
+	}
+	return qc.GithubClient.LastForcePush(number)
+}
+
+// query runs query and decodes its data into v. GraphQL reports errors in
+// the body of successful responses, those are returned as well.
+func (qc *GraphQLClient) query(query string, variables map[string]interface{}, v interface{}) error {
+	req, err := qc.client.NewRequest("POST", qc.endpoint, &graphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		return err
+	}
+
+	var graphQLResp graphQLResponse
+	resp, err := qc.client.Do(context.TODO(), req, &graphQLResp)
+	if err != nil {
+		return err
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return fmt.Errorf("closing resp body: %+v", err)
+	}
+
+	if len(graphQLResp.Errors) > 0 {
+		messages := []string{}
+		for _, e := range graphQLResp.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("graphql: %s", strings.Join(messages, ", "))
+	}
+	return json.Unmarshal(graphQLResp.Data, v)
+}
+
+// convertPull turns pull into a Pull, remembering its files, reviews and
+// force pushes and adding its head commit to the commit cache.
+func (qc *GraphQLClient) convertPull(pull *graphQLPull) *Pull {
+	var labels = []string{}
+	for _, label := range pull.Labels.Nodes {
+		labels = append(labels, label.Name)
+	}
+
+	var mergeable *bool
+	switch pull.Mergeable {
+	case "MERGEABLE", "CONFLICTING":
+		m := pull.Mergeable == "MERGEABLE"
+		mergeable = &m
+	}
+
+	var author string
+	if pull.Author != nil {
+		author = pull.Author.Login
+	}
+
+	var baseRepo, headRepo string
+	if pull.BaseRepository != nil {
+		baseRepo = pull.BaseRepository.NameWithOwner
+	}
+	if pull.HeadRepository != nil {
+		headRepo = pull.HeadRepository.NameWithOwner
+	}
+
+	var mergeCommitSHA string
+	state := strings.ToLower(pull.State)
+	if state == pullStateMerged && pull.MergeCommit != nil {
+		mergeCommitSHA = pull.MergeCommit.Oid
+	}
+
+	converted := &Pull{
+		Number:          pull.Number,
+		LatestCommitSHA: pull.HeadRefOid,
+		Ref:             pull.HeadRefOid,
+		URL:             fmt.Sprintf("%srepos/%s/%s/pulls/%d", qc.client.BaseURL, qc.owner, qc.repo, pull.Number),
+		Title:           pull.Title,
+		Body:            pull.Body,
+		Labels:          labels,
+		UpdatedAt:       pull.UpdatedAt,
+		CreatedAt:       pull.CreatedAt,
+		BaseRef:         pull.BaseRefName,
+		BaseSHA:         pull.BaseRefOid,
+		Draft:           pull.IsDraft,
+		Mergeable:       mergeable,
+		Author:          author,
+		HTMLURL:         pull.URL,
+		HeadRef:         pull.HeadRefName,
+		HeadRepo:        headRepo,
+		Fork:            headRepo != baseRepo,
+		State:           state,
+		MergeCommitSHA:  mergeCommitSHA,
+	}
+
+	if len(pull.Files.Nodes) >= pull.Files.TotalCount {
+		files := []string{}
+		for _, file := range pull.Files.Nodes {
+			files = append(files, file.Path)
+		}
+		qc.files[pull.Number] = files
+	}
+
+	if len(pull.Reviews.Nodes) >= pull.Reviews.TotalCount {
+		reviews := []*Review{}
+		for _, review := range pull.Reviews.Nodes {
+			item := &Review{
+				ID:                review.DatabaseID,
+				AuthorAssociation: review.AuthorAssociation,
+				State:             review.State,
+				SubmittedAt:       review.SubmittedAt,
+			}
+			if review.Author != nil {
+				item.Author = review.Author.Login
+			}
+			if review.Commit != nil {
+				item.CommitID = review.Commit.Oid
+			}
+			reviews = append(reviews, item)
+		}
+		qc.reviews[pull.Number] = reviews
+	}
+
+	var forcePushedAt time.Time
+	for _, node := range pull.TimelineItems.Nodes {
+		forcePushedAt = latest(forcePushedAt, node.CreatedAt)
+	}
+	qc.forcePushes[pull.Number] = forcePushedAt
+
+	for _, node := range pull.Commits.Nodes {
+		if node.Commit.Oid == pull.HeadRefOid {
+			qc.commits[node.Commit.Oid] = &Commit{
+				SHA:         node.Commit.Oid,
+				Message:     node.Commit.Message,
+				CommittedAt: node.Commit.CommittedDate,
+			}
+			if node.Commit.Status != nil {
+				converted.Status = strings.ToLower(node.Commit.Status.State)
+			}
+		}
+	}
+
+	return converted
+}
@@ -1,9 +1,16 @@
 package resource
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+
+	log "github.com/sirupsen/logrus"
 )
 
 // InCommand is
@@ -25,26 +32,256 @@ func (ic *InCommand) Run(destDir string, req InRequest) (InResponse, error) {
 		return resp, err
 	}
 
-	pulls, err := ic.github.ListPRs()
+	pull, err := ic.findPull(req.Version)
+	if err != nil {
+		return resp, err
+	}
+
+	allowed, err := forkAllowed(ic.github, req.Source, pull)
+	if err != nil {
+		return resp, err
+	}
+	if !allowed {
+		return resp, fmt.Errorf("pr %d from fork %s is not allowed by fork_policy %s", pull.Number, pull.HeadRepo, req.Source.ForkPolicy)
+	}
+
+	err = ic.download(destDir, pull, req)
+	if err != nil {
+		return resp, err
+	}
+
+	reviews, err := ic.github.ListReviews(pull.Number)
+	if err != nil {
+		return resp, err
+	}
+
+	err = writeReviewsToFile(destDir, reviews)
+	if err != nil {
+		return resp, err
+	}
+
+	if req.Source.CommentTrigger != "" {
+		err = ic.writeTriggerComment(destDir, pull, req.Version)
+		if err != nil {
+			return resp, err
+		}
+	}
+
+	checkout := req.InParams.Checkout
+	if checkout == "" {
+		checkout = "head"
+	}
+
+	version := req.Version
+	version.PR = strconv.Itoa(pull.Number)
+
+	err = writeVersionToFile(destDir, version)
 	if err != nil {
 		return resp, err
 	}
 
-	for _, pull := range pulls {
-		if pull.Ref == req.Version.Ref {
-			err = ic.github.DownloadPR(destDir, pull.Number)
-			if err != nil {
-				return resp, err
+	return InResponse{
+		Version:  version,
+		Metadata: append(pullMetadata(pull), Metadata{Name: "checkout", Value: checkout}),
+	}, nil
+}
+
+// findPull returns the pull of version pinned to the head commit version
+// names. Versions with a pr number are looked up directly, so they are found
+// even after the pull was closed, older versions are searched for among the
+// open pulls.
+func (ic *InCommand) findPull(version Version) (*Pull, error) {
+	if version.PR == "" {
+		pulls, err := ic.github.ListPRs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pull := range pulls {
+			if version.identifies(pull) {
+				return pull, nil
+			}
+		}
+		return nil, fmt.Errorf("version %s not found", version.Ref)
+	}
+
+	number, err := strconv.Atoi(version.PR)
+	if err != nil {
+		return nil, fmt.Errorf("parsing pr %q: %+v", version.PR, err)
+	}
+
+	pull, err := ic.github.GetPR(number)
+	if err != nil {
+		return nil, err
+	}
+
+	if version.identifies(pull) {
+		return pull, nil
+	}
+
+	// Legacy refs only hold a prefix of the head sha, which cannot be fetched.
+	if legacyRefPattern.MatchString(version.Ref) {
+		return nil, fmt.Errorf("version %s not found, pr %d moved on to %s", version.Ref, number, pull.LatestCommitSHA)
+	}
+
+	pinned := *pull
+	pinned.Ref = version.Ref
+	pinned.LatestCommitSHA = version.Ref
+	return &pinned, nil
+}
+
+// writeTriggerComment writes the comment that triggered version, leaving the
+// files empty when the version was not triggered by one.
+func (ic *InCommand) writeTriggerComment(destDir string, pull *Pull, version Version) error {
+	if version.CommentID == "" {
+		return writeTriggerCommentToFile(destDir, nil)
+	}
+
+	comments, err := ic.github.ListComments(pull.Number)
+	if err != nil {
+		return err
+	}
+
+	comment := findComment(comments, version.CommentID)
+	if comment == nil {
+		log.Warnf("comment %s of pr %d not found, it was deleted", version.CommentID, pull.Number)
+	}
+	return writeTriggerCommentToFile(destDir, comment)
+}
+
+func (ic *InCommand) download(destDir string, pull *Pull, req InRequest) error {
+	params := req.InParams
+
+	if len(params.Globs) == 0 {
+		if err := ic.github.DownloadPR(destDir, pull, params); err != nil {
+			return err
+		}
+	} else {
+		checkoutDir, err := ioutil.TempDir("", "pr-checkout")
+		if err != nil {
+			return fmt.Errorf("creating checkout dir: %+v", err)
+		}
+		defer os.RemoveAll(checkoutDir)
+
+		if err = ic.github.DownloadPR(checkoutDir, pull, params); err != nil {
+			return err
+		}
+
+		if err = copyGlobs(checkoutDir, destDir, params.Globs); err != nil {
+			return err
+		}
+
+		if err = writePullToFile(destDir, pull); err != nil {
+			return err
+		}
+	}
+
+	if params.IncludeSourceTarball {
+		if err := ic.downloadArchive(destDir, "tarball", "source.tar.gz", pull, req.Source); err != nil {
+			return err
+		}
+	}
+
+	if params.IncludeSourceZip {
+		if err := ic.downloadArchive(destDir, "zipball", "source.zip", pull, req.Source); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ic *InCommand) downloadArchive(destDir, format, fileName string, pull *Pull, source Source) error {
+	link, err := ic.github.GetArchiveLink(format, pull.LatestCommitSHA)
+	if err != nil {
+		return err
+	}
+
+	httpClient := &http.Client{}
+	if source.Insecure {
+		httpClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	resp, err := httpClient.Get(link)
+	if err != nil {
+		return fmt.Errorf("downloading %s: %+v", format, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: unexpected status %s", format, resp.Status)
+	}
+
+	file, err := os.Create(filepath.Join(destDir, fileName))
+	if err != nil {
+		return fmt.Errorf("creating %s: %+v", fileName, err)
+	}
+	defer file.Close()
+
+	if _, err = io.Copy(file, resp.Body); err != nil {
+		return fmt.Errorf("writing %s: %+v", fileName, err)
+	}
+	return nil
+}
+
+// copyGlobs copies the files below srcDir whose relative path matches one of
+// globs into destDir, keeping the directory layout. The .git directory is
+// never copied.
+func copyGlobs(srcDir, destDir string, globs []string) error {
+	return filepath.Walk(srcDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, file)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if rel == ".git" {
+				return filepath.SkipDir
 			}
+			return nil
+		}
 
-			return InResponse{
-				Version: Version{
-					Ref: req.Version.Ref,
-					PR:  strconv.Itoa(pull.Number),
-				},
-			}, nil
+		if !matchesAnyPath(filepath.ToSlash(rel), globs) {
+			return nil
 		}
+
+		return copyFile(file, filepath.Join(destDir, rel), info)
+	})
+}
+
+func copyFile(src, dest string, info os.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("creating dir for %s: %+v", dest, err)
 	}
 
-	return resp, fmt.Errorf("version %s not found", req.Version.Ref)
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return fmt.Errorf("reading link %s: %+v", src, err)
+		}
+		return os.Symlink(target, dest)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening %s: %+v", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("creating %s: %+v", dest, err)
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, in); err != nil {
+		return fmt.Errorf("copying %s: %+v", src, err)
+	}
+	return nil
 }
@@ -2,8 +2,12 @@ package resource_test
 
 import (
 	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -20,7 +24,7 @@ var _ = Describe("CheckCommand", func() {
 	})
 
 	AfterEach(func() {
-		os.Remove(fakeDestDir)
+		os.RemoveAll(fakeDestDir)
 	})
 
 	Context("when version is valid", func() {
@@ -41,6 +45,319 @@ var _ = Describe("CheckCommand", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(inResponse.Version.Ref).To(Equal("fake-ref1"))
 		})
+		It("should return the given version for a pull whose base moved", func() {
+			fakeGithub := &fake.FGithub{
+				ListPRResult: []*r.Pull{
+					&r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1", BaseSHA: "fake-base2"},
+				},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{
+				Source:  r.Source{VersionTrigger: "base"},
+				Version: r.Version{Ref: "fake-sha1", PR: "1", BaseSHA: "fake-base1"},
+			}
+
+			inResponse, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(inResponse.Version).To(Equal(r.Version{Ref: "fake-sha1", PR: "1", BaseSHA: "fake-base1"}))
+		})
+
+		It("should find the pull of a legacy version", func() {
+			fakeGithub := &fake.FGithub{
+				ListPRResult: []*r.Pull{
+					&r.Pull{Number: 1, Ref: "abcdef0123", LatestCommitSHA: "abcdef0123"},
+					&r.Pull{Number: 2, Ref: "1234567abc", LatestCommitSHA: "1234567abc"},
+				},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{
+				Source:  r.Source{},
+				Version: r.Version{Ref: "1234567-2018-06-01T12:30:00Z"},
+			}
+
+			inResponse, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(inResponse.Version).To(Equal(r.Version{Ref: "1234567-2018-06-01T12:30:00Z", PR: "2"}))
+		})
+
+		It("should write the version for put to return", func() {
+			fakeGithub := &fake.FGithub{
+				GetPRResult: &r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1"},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{
+				Version: r.Version{Ref: "fake-sha1", PR: "1", PushedAt: "2018-06-01T12:00:00Z"},
+			}
+
+			_, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+
+			content, err := ioutil.ReadFile(path.Join(fakeDestDir, "version.json"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(content).To(MatchJSON(`{"ref":"fake-sha1","pr":"1","pushed_at":"2018-06-01T12:00:00Z"}`))
+		})
+
+		It("should return metadata of the pull", func() {
+			fakeGithub := &fake.FGithub{
+				ListPRResult: []*r.Pull{
+					&r.Pull{
+						Number:          1,
+						Ref:             "fake-ref1",
+						Title:           "fake-title",
+						Author:          "fake-author",
+						HTMLURL:         "https://github.com/fake-owner/fake-repo/pull/1",
+						LatestCommitSHA: "fake-sha1",
+						BaseRef:         "master",
+						Labels:          []string{"ready", "wip"},
+						UpdatedAt:       time.Date(2018, 6, 1, 12, 30, 0, 0, time.UTC),
+						State:           "open",
+					},
+				},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{
+				Source:   r.Source{},
+				Version:  r.Version{Ref: "fake-ref1"},
+				InParams: r.InParams{Checkout: "merge"},
+			}
+
+			inResponse, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(inResponse.Metadata).To(Equal([]r.Metadata{
+				{Name: "pr", Value: "1"},
+				{Name: "title", Value: "fake-title"},
+				{Name: "author", Value: "fake-author"},
+				{Name: "url", Value: "https://github.com/fake-owner/fake-repo/pull/1"},
+				{Name: "head_sha", Value: "fake-sha1"},
+				{Name: "base", Value: "master"},
+				{Name: "labels", Value: "ready, wip"},
+				{Name: "updated_at", Value: "2018-06-01T12:30:00Z"},
+				{Name: "state", Value: "open"},
+				{Name: "merge_commit_sha", Value: ""},
+				{Name: "checkout", Value: "merge"},
+			}))
+		})
+	})
+
+	Context("when version has a pr number", func() {
+		It("should get the pull without listing", func() {
+			fakeGithub := &fake.FGithub{
+				ListPRError: errors.New("fake-list-error"),
+				GetPRResult: &r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1"},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{
+				Version: r.Version{Ref: "fake-sha1", PR: "1"},
+			}
+
+			inResponse, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(inResponse.Version).To(Equal(r.Version{Ref: "fake-sha1", PR: "1"}))
+			Expect(fakeGithub.DownloadPRPull).To(Equal(fakeGithub.GetPRResult))
+		})
+
+		It("should pin the pull to the version when it moved on", func() {
+			fakeGithub := &fake.FGithub{
+				GetPRResult: &r.Pull{Number: 1, Ref: "fake-sha2", LatestCommitSHA: "fake-sha2", Title: "fake-title"},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{
+				Version: r.Version{Ref: "fake-sha1", PR: "1"},
+			}
+
+			inResponse, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(inResponse.Version).To(Equal(r.Version{Ref: "fake-sha1", PR: "1"}))
+			Expect(fakeGithub.DownloadPRPull.LatestCommitSHA).To(Equal("fake-sha1"))
+			Expect(fakeGithub.DownloadPRPull.Title).To(Equal("fake-title"))
+			Expect(fakeGithub.GetPRResult.LatestCommitSHA).To(Equal("fake-sha2"))
+			Expect(inResponse.Metadata).To(ContainElement(r.Metadata{Name: "head_sha", Value: "fake-sha1"}))
+		})
+
+		It("should return error for a legacy version whose pull moved on", func() {
+			fakeGithub := &fake.FGithub{
+				GetPRResult: &r.Pull{Number: 1, Ref: "1234567abc", LatestCommitSHA: "1234567abc"},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{
+				Version: r.Version{Ref: "abcdef0-2018-06-01T12:30:00Z", PR: "1"},
+			}
+
+			_, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).To(MatchError("version abcdef0-2018-06-01T12:30:00Z not found, pr 1 moved on to 1234567abc"))
+		})
+
+		It("should return error when getting the pull fails", func() {
+			fakeGithub := &fake.FGithub{GetPRError: errors.New("fake-get-error")}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{
+				Version: r.Version{Ref: "fake-sha1", PR: "1"},
+			}
+
+			_, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).To(MatchError("fake-get-error"))
+		})
+	})
+
+	Context("when the pull is from a fork", func() {
+		It("should refuse forks the fork_policy does not allow", func() {
+			fakeGithub := &fake.FGithub{
+				GetPRResult: &r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1", Fork: true, HeadRepo: "someone/fake-repo"},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{
+				Source:  r.Source{ForkPolicy: "require_label"},
+				Version: r.Version{Ref: "fake-sha1", PR: "1"},
+			}
+
+			_, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).To(MatchError("pr 1 from fork someone/fake-repo is not allowed by fork_policy require_label"))
+			Expect(fakeGithub.DownloadPRPull).To(BeNil())
+		})
+
+		It("should get forks the fork_policy allows", func() {
+			fakeGithub := &fake.FGithub{
+				GetPRResult: &r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1", Fork: true, Labels: []string{"ok-to-test"}},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{
+				Source:  r.Source{ForkPolicy: "require_label"},
+				Version: r.Version{Ref: "fake-sha1", PR: "1"},
+			}
+
+			_, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.DownloadPRPull).ToNot(BeNil())
+		})
+		It("should refuse forks pushed to after they were labelled", func() {
+			fakeGithub := &fake.FGithub{
+				GetPRResult: &r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1", Fork: true, HeadRepo: "someone/fake-repo", Labels: []string{"ok-to-test"}},
+				StaleLabels: map[int]bool{1: true},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{
+				Source:  r.Source{ForkPolicy: "require_label"},
+				Version: r.Version{Ref: "fake-sha1", PR: "1"},
+			}
+
+			_, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).To(MatchError("pr 1 from fork someone/fake-repo is not allowed by fork_policy require_label"))
+		})
+	})
+
+	Context("when the pull was reviewed", func() {
+		It("should write the review state", func() {
+			fakeGithub := &fake.FGithub{
+				GetPRResult: &r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1"},
+				ListReviewsResult: map[int][]*r.Review{
+					1: []*r.Review{
+						{Author: "alice", AuthorAssociation: "MEMBER", State: "APPROVED"},
+						{Author: "bob", AuthorAssociation: "COLLABORATOR", State: "APPROVED"},
+					},
+				},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{Version: r.Version{Ref: "fake-sha1", PR: "1"}}
+
+			_, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+
+			state, err := ioutil.ReadFile(path.Join(fakeDestDir, "pr_review_state"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(state)).To(Equal("approved"))
+
+			approvedBy, err := ioutil.ReadFile(path.Join(fakeDestDir, "pr_approved_by"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(approvedBy)).To(Equal("alice\nbob\n"))
+
+			approvals, err := ioutil.ReadFile(path.Join(fakeDestDir, "pr_approvals"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(approvals)).To(Equal("2"))
+		})
+
+		It("should write a pending change request", func() {
+			fakeGithub := &fake.FGithub{
+				GetPRResult: &r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1"},
+				ListReviewsResult: map[int][]*r.Review{
+					1: []*r.Review{
+						{Author: "alice", AuthorAssociation: "MEMBER", State: "APPROVED"},
+						{Author: "bob", AuthorAssociation: "MEMBER", State: "CHANGES_REQUESTED"},
+					},
+				},
+			}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{Version: r.Version{Ref: "fake-sha1", PR: "1"}}
+
+			_, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+
+			state, err := ioutil.ReadFile(path.Join(fakeDestDir, "pr_review_state"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(state)).To(Equal("changes_requested"))
+		})
+
+		It("should return error when listing reviews fails", func() {
+			fakeGithub := &fake.FGithub{ListReviewsError: errors.New("fake-reviews-error")}
+			inCommand := r.NewInCommand(fakeGithub)
+			inRequest := r.InRequest{Version: r.Version{Ref: "fake-sha1", PR: "1"}}
+
+			_, err := inCommand.Run(fakeDestDir, inRequest)
+			Expect(err).To(MatchError("fake-reviews-error"))
+		})
+	})
+
+	Context("when source has a comment_trigger", func() {
+		var fakeGithub *fake.FGithub
+
+		BeforeEach(func() {
+			fakeGithub = &fake.FGithub{
+				GetPRResult: &r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1"},
+				ListCommentsResult: []*r.Comment{
+					{ID: 5, Body: "/retest integration", Author: "alice"},
+				},
+			}
+		})
+
+		readTrigger := func() (string, string) {
+			body, err := ioutil.ReadFile(path.Join(fakeDestDir, "pr_trigger_comment"))
+			Expect(err).ToNot(HaveOccurred())
+			author, err := ioutil.ReadFile(path.Join(fakeDestDir, "pr_trigger_comment_author"))
+			Expect(err).ToNot(HaveOccurred())
+			return string(body), string(author)
+		}
+
+		It("should write the triggering comment", func() {
+			_, err := r.NewInCommand(fakeGithub).Run(fakeDestDir, r.InRequest{
+				Source:  r.Source{CommentTrigger: "^/retest"},
+				Version: r.Version{Ref: "fake-sha1", PR: "1", CommentID: "5"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			body, author := readTrigger()
+			Expect(body).To(Equal("/retest integration"))
+			Expect(author).To(Equal("alice"))
+		})
+
+		It("should write empty files when the version was not triggered by a comment", func() {
+			_, err := r.NewInCommand(fakeGithub).Run(fakeDestDir, r.InRequest{
+				Source:  r.Source{CommentTrigger: "^/retest"},
+				Version: r.Version{Ref: "fake-sha1", PR: "1"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			body, author := readTrigger()
+			Expect(body).To(BeEmpty())
+			Expect(author).To(BeEmpty())
+		})
+
+		It("should return error when listing comments fails", func() {
+			fakeGithub.ListCommentsError = errors.New("fake-comments-error")
+			_, err := r.NewInCommand(fakeGithub).Run(fakeDestDir, r.InRequest{
+				Source:  r.Source{CommentTrigger: "^/retest"},
+				Version: r.Version{Ref: "fake-sha1", PR: "1", CommentID: "5"},
+			})
+			Expect(err).To(MatchError("fake-comments-error"))
+		})
 	})
 
 	Context("when creating a folder fails", func() {
@@ -134,4 +451,131 @@ var _ = Describe("CheckCommand", func() {
 			Expect(err.Error()).To(Equal("version fake-sha3 not found"))
 		})
 	})
+
+	Context("when params are given", func() {
+		var destDir string
+		var server *httptest.Server
+		var fakeGithub *fake.FGithub
+		var inRequest r.InRequest
+
+		BeforeEach(func() {
+			var err error
+			destDir, err = ioutil.TempDir("", "in-command")
+			Expect(err).ToNot(HaveOccurred())
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				switch req.URL.Path {
+				case "/tarball/fake-sha1":
+					w.Write([]byte("fake-tarball"))
+				case "/zipball/fake-sha1":
+					w.Write([]byte("fake-zipball"))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+
+			fakeGithub = &fake.FGithub{
+				ListPRResult: []*r.Pull{
+					&r.Pull{Number: 1, Ref: "fake-ref1", LatestCommitSHA: "fake-sha1"},
+				},
+				DownloadPRFiles: map[string]string{
+					"README.md":         "readme",
+					"src/main.go":       "main",
+					"src/lib/util.go":   "util",
+					"docs/index.md":     "docs",
+					".git/HEAD":         "ref: refs/heads/pr",
+					"release/notes.txt": "notes",
+				},
+				GetArchiveLinkResult: map[string]string{
+					"tarball": server.URL + "/tarball/fake-sha1",
+					"zipball": server.URL + "/zipball/fake-sha1",
+				},
+			}
+			inRequest = r.InRequest{Version: r.Version{Ref: "fake-ref1"}}
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.RemoveAll(destDir)
+		})
+
+		readFile := func(name string) string {
+			content, err := ioutil.ReadFile(path.Join(destDir, name))
+			Expect(err).ToNot(HaveOccurred())
+			return string(content)
+		}
+
+		It("should do a full checkout without params", func() {
+			_, err := r.NewInCommand(fakeGithub).Run(destDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(readFile("src/main.go")).To(Equal("main"))
+			Expect(readFile(".git/HEAD")).To(Equal("ref: refs/heads/pr"))
+			Expect(path.Join(destDir, "source.tar.gz")).ToNot(BeAnExistingFile())
+			Expect(path.Join(destDir, "source.zip")).ToNot(BeAnExistingFile())
+		})
+
+		It("should only place files matching globs", func() {
+			inRequest.InParams.Globs = []string{"src/*.go", "docs"}
+
+			_, err := r.NewInCommand(fakeGithub).Run(destDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(readFile("src/main.go")).To(Equal("main"))
+			Expect(readFile("docs/index.md")).To(Equal("docs"))
+			Expect(path.Join(destDir, "src/lib/util.go")).ToNot(BeAnExistingFile())
+			Expect(path.Join(destDir, "README.md")).ToNot(BeAnExistingFile())
+			Expect(path.Join(destDir, ".git")).ToNot(BeAnExistingFile())
+			Expect(readFile("pr_number")).To(Equal("1"))
+			Expect(readFile("pr_last_commit_hash")).To(Equal("fake-sha1"))
+		})
+
+		It("should download the source tarball", func() {
+			inRequest.InParams.IncludeSourceTarball = true
+
+			_, err := r.NewInCommand(fakeGithub).Run(destDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(readFile("source.tar.gz")).To(Equal("fake-tarball"))
+			Expect(path.Join(destDir, "source.zip")).ToNot(BeAnExistingFile())
+		})
+
+		It("should download the source zip", func() {
+			inRequest.InParams.IncludeSourceZip = true
+
+			_, err := r.NewInCommand(fakeGithub).Run(destDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(readFile("source.zip")).To(Equal("fake-zipball"))
+			Expect(path.Join(destDir, "source.tar.gz")).ToNot(BeAnExistingFile())
+		})
+
+		It("should combine globs with both archives", func() {
+			inRequest.InParams = r.InParams{
+				Globs:                []string{"README.md"},
+				IncludeSourceTarball: true,
+				IncludeSourceZip:     true,
+			}
+
+			_, err := r.NewInCommand(fakeGithub).Run(destDir, inRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(readFile("README.md")).To(Equal("readme"))
+			Expect(path.Join(destDir, "src")).ToNot(BeAnExistingFile())
+			Expect(readFile("source.tar.gz")).To(Equal("fake-tarball"))
+			Expect(readFile("source.zip")).To(Equal("fake-zipball"))
+		})
+
+		It("should return error when getting the archive link fails", func() {
+			inRequest.InParams.IncludeSourceTarball = true
+			fakeGithub.GetArchiveLinkError = errors.New("fake-link-error")
+
+			_, err := r.NewInCommand(fakeGithub).Run(destDir, inRequest)
+			Expect(err).To(MatchError("fake-link-error"))
+		})
+
+		It("should return error when the archive download fails", func() {
+			inRequest.InParams.IncludeSourceZip = true
+			fakeGithub.GetArchiveLinkResult["zipball"] = server.URL + "/missing"
+
+			_, err := r.NewInCommand(fakeGithub).Run(destDir, inRequest)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("downloading zipball: unexpected status 404 Not Found"))
+		})
+	})
 })
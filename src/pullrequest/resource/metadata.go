@@ -0,0 +1,27 @@
+package resource
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+func pullMetadata(pull *Pull) []Metadata {
+	var updatedAt string
+	if !pull.UpdatedAt.IsZero() {
+		updatedAt = pull.UpdatedAt.Format(time.RFC3339)
+	}
+
+	return []Metadata{
+		{Name: "pr", Value: strconv.Itoa(pull.Number)},
+		{Name: "title", Value: pull.Title},
+		{Name: "author", Value: pull.Author},
+		{Name: "url", Value: pull.HTMLURL},
+		{Name: "head_sha", Value: pull.LatestCommitSHA},
+		{Name: "base", Value: pull.BaseRef},
+		{Name: "labels", Value: strings.Join(pull.Labels, ", ")},
+		{Name: "updated_at", Value: updatedAt},
+		{Name: "state", Value: pull.State},
+		{Name: "merge_commit_sha", Value: pull.MergeCommitSHA},
+	}
+}
@@ -1,11 +1,26 @@
 package resource
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
 )
 
+const defaultTargetURL = "$ATC_EXTERNAL_URL/builds/$BUILD_ID"
+
+// commentMarkerFormat is a markdown comment, invisible on the rendered PR.
+const commentMarkerFormat = "<!-- concourse-pullrequest-resource: %s -->"
+
+// GitHub rejects statuses with longer descriptions.
+const maxStatusDescriptionLength = 140
+
 // OutCommand is
 type OutCommand struct {
 	github Github
@@ -25,15 +40,408 @@ func (oc *OutCommand) Run(sourceDir string, req OutRequest) (OutResponse, error)
 		return OutResponse{}, fmt.Errorf("reading pr_number: %+v", err)
 	}
 
-	ref, err := oc.github.UpdatePR(sourceDir, params.Status, params.Path)
+	switch params.Mode {
+	case "", "status", "check_run":
+	default:
+		return OutResponse{}, fmt.Errorf("%s is not a valid mode", params.Mode)
+	}
+
+	// GitHub answers anyone else with a bare 403.
+	if params.Mode == "check_run" {
+		app, err := usesApp(req.Source)
+		if err != nil {
+			return OutResponse{}, err
+		}
+		if !app {
+			return OutResponse{}, errors.New("mode check_run needs app_id, installation_id and private_key, only GitHub Apps can create check runs")
+		}
+	}
+
+	hasComment := params.Comment != "" || params.CommentFile != ""
+	hasLabels := len(params.AddLabels) > 0 || params.AddLabelsFile != "" ||
+		len(params.RemoveLabels) > 0 || params.RemoveLabelsFile != ""
+	status := commitStatus(req.Source, params)
+	metadata := oc.metadata(string(prNumber))
+
+	var ref string
+	if params.Mode == "check_run" {
+		checkRun, err := newCheckRun(sourceDir, status, params)
+		if err != nil {
+			return OutResponse{}, err
+		}
+
+		ref, err = oc.github.UpdateCheckRun(sourceDir, checkRun, params.Path)
+		if err != nil {
+			return OutResponse{}, fmt.Errorf("updating check run: %+v", err)
+		}
+
+		metadata = append(metadata, Metadata{Name: "check_run", Value: checkRun.Name})
+		metadata = append(metadata, Metadata{Name: "check_status", Value: checkRun.Status})
+		if checkRun.Conclusion != "" {
+			metadata = append(metadata, Metadata{Name: "conclusion", Value: checkRun.Conclusion})
+		}
+	} else if params.Status != "" || (!hasComment && !hasLabels && params.Merge == nil) {
+		ref, err = oc.github.UpdatePR(sourceDir, status, params.Path)
+		if err != nil {
+			return OutResponse{}, fmt.Errorf("updating pr: %+v", err)
+		}
+
+		metadata = append(metadata, Metadata{Name: "status", Value: params.Status})
+	} else {
+		idBytes, err := ioutil.ReadFile(path.Join(sourceDir, params.Path, "pr_id"))
+		if err != nil {
+			return OutResponse{}, fmt.Errorf("reading pr_id: %+v", err)
+		}
+		ref = string(idBytes)
+	}
+
+	if hasComment {
+		comment, err := oc.comment(sourceDir, string(prNumber), status.Context, params)
+		if err != nil {
+			return OutResponse{}, fmt.Errorf("commenting on pr: %+v", err)
+		}
+		metadata = append(metadata, Metadata{Name: "comment", Value: comment.HTMLURL})
+	}
+
+	if hasLabels {
+		added, removed, err := oc.labels(sourceDir, string(prNumber), params)
+		if err != nil {
+			return OutResponse{}, fmt.Errorf("labelling pr: %+v", err)
+		}
+		metadata = append(metadata, Metadata{Name: "added_labels", Value: strings.Join(added, ", ")})
+		metadata = append(metadata, Metadata{Name: "removed_labels", Value: strings.Join(removed, ", ")})
+	}
+
+	if params.Merge != nil {
+		mergeMetadata, err := oc.merge(sourceDir, string(prNumber), req.Source, params)
+		if err != nil {
+			return OutResponse{}, fmt.Errorf("merging pr: %+v", err)
+		}
+		metadata = append(metadata, mergeMetadata...)
+	}
+
+	// Returning the version get fetched keeps put from creating a version
+	// check never emits, which differs by the fields check adds.
+	version, ok, err := readVersionFromFile(path.Join(sourceDir, params.Path))
 	if err != nil {
-		return OutResponse{}, fmt.Errorf("updating pr: %+v", err)
+		return OutResponse{}, err
+	}
+	if !ok {
+		version = Version{Ref: ref, PR: string(prNumber)}
 	}
 
 	return OutResponse{
-		Version: Version{
-			Ref: ref,
-			PR:  string(prNumber),
-		},
+		Version:  version,
+		Metadata: metadata,
 	}, nil
 }
+
+// comment posts the comment of params on the pull. With edit_comment set the
+// latest comment the client posted with the same hidden marker is edited
+// instead, the marker defaults to the status context so every job keeps its
+// own comment.
+func (oc *OutCommand) comment(sourceDir, prNumber, statusContext string, params OutParams) (*Comment, error) {
+	number, err := strconv.Atoi(strings.TrimSpace(prNumber))
+	if err != nil {
+		return nil, fmt.Errorf("parsing pr_number %q: %+v", prNumber, err)
+	}
+
+	body := os.ExpandEnv(params.Comment)
+	if params.CommentFile != "" {
+		content, err := ioutil.ReadFile(path.Join(sourceDir, params.CommentFile))
+		if err != nil {
+			return nil, fmt.Errorf("reading comment_file: %+v", err)
+		}
+		body = string(content)
+	}
+
+	if !params.EditComment {
+		return oc.github.CreateComment(number, body)
+	}
+
+	marker := params.CommentMarker
+	if marker == "" {
+		marker = statusContext
+	}
+	marker = fmt.Sprintf(commentMarkerFormat, os.ExpandEnv(marker))
+	body = marker + "\n" + body
+
+	comments, err := oc.github.ListComments(number)
+	if err != nil {
+		return nil, err
+	}
+
+	// Anyone may quote the marker.
+	login, err := oc.github.Login()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(comments) - 1; i >= 0; i-- {
+		if strings.EqualFold(comments[i].Author, login) && strings.Contains(comments[i].Body, marker) {
+			return oc.github.EditComment(comments[i].ID, body)
+		}
+	}
+	return oc.github.CreateComment(number, body)
+}
+
+// labels adds and removes the labels of params on the pull, returning the
+// labels it added and removed.
+func (oc *OutCommand) labels(sourceDir, prNumber string, params OutParams) ([]string, []string, error) {
+	number, err := strconv.Atoi(strings.TrimSpace(prNumber))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing pr_number %q: %+v", prNumber, err)
+	}
+
+	add, err := labelList(sourceDir, params.AddLabels, params.AddLabelsFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading add_labels_file: %+v", err)
+	}
+
+	remove, err := labelList(sourceDir, params.RemoveLabels, params.RemoveLabelsFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading remove_labels_file: %+v", err)
+	}
+
+	if len(add) > 0 {
+		if err = oc.github.AddLabels(number, add); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, label := range remove {
+		if err = oc.github.RemoveLabel(number, label); err != nil {
+			return nil, nil, err
+		}
+	}
+	return add, remove, nil
+}
+
+// labelList expands the labels given inline and appends those listed one per
+// line in file, if any.
+func labelList(sourceDir string, labels []string, file string) ([]string, error) {
+	list := []string{}
+	for _, label := range labels {
+		if label = strings.TrimSpace(os.ExpandEnv(label)); label != "" {
+			list = append(list, label)
+		}
+	}
+
+	if file == "" {
+		return list, nil
+	}
+
+	content, err := ioutil.ReadFile(path.Join(sourceDir, file))
+	if err != nil {
+		return nil, err
+	}
+	for _, label := range strings.Split(string(content), "\n") {
+		if label = strings.TrimSpace(label); label != "" {
+			list = append(list, label)
+		}
+	}
+	return list, nil
+}
+
+// merge merges the pull as described by params.Merge. With require_head_sha
+// the merge is refused when the pull moved on since it was fetched by in.
+func (oc *OutCommand) merge(sourceDir, prNumber string, source Source, params OutParams) ([]Metadata, error) {
+	mergeParams := params.Merge
+
+	switch mergeParams.Method {
+	case "", "merge", "squash", "rebase":
+	default:
+		return nil, fmt.Errorf("%s is not a valid merge method", mergeParams.Method)
+	}
+
+	number, err := strconv.Atoi(strings.TrimSpace(prNumber))
+	if err != nil {
+		return nil, fmt.Errorf("parsing pr_number %q: %+v", prNumber, err)
+	}
+
+	pull, err := oc.github.GetPR(number)
+	if err != nil {
+		return nil, fmt.Errorf("getting pr %d: %+v", number, err)
+	}
+
+	merge := MergeRequest{
+		Method:        mergeParams.Method,
+		CommitTitle:   expandPull(mergeParams.CommitTitle, pull),
+		CommitMessage: expandPull(mergeParams.CommitMessage, pull),
+	}
+
+	if mergeParams.RequireHeadSHA {
+		headSHA, err := ioutil.ReadFile(path.Join(sourceDir, params.Path, "pr_last_commit_hash"))
+		if err != nil {
+			return nil, fmt.Errorf("reading pr_last_commit_hash: %+v", err)
+		}
+		merge.SHA = strings.TrimSpace(string(headSHA))
+
+		if pull.LatestCommitSHA != merge.SHA {
+			return nil, fmt.Errorf("pr %d moved from %s to %s since it was fetched, not merging", number, merge.SHA, pull.LatestCommitSHA)
+		}
+	}
+
+	mergeSHA, err := oc.github.MergePR(number, merge)
+	if err != nil {
+		return nil, err
+	}
+
+	method := merge.Method
+	if method == "" {
+		method = "merge"
+	}
+	metadata := []Metadata{
+		{Name: "merge_commit_sha", Value: mergeSHA},
+		{Name: "merge_method", Value: method},
+	}
+
+	if mergeParams.DeleteBranch {
+		// Branches of forks cannot be deleted with access to this repository.
+		if !strings.EqualFold(pull.HeadRepo, source.Owner+"/"+source.Repo) {
+			log.Warnf("not deleting branch %s of pr %d, it lives in %s", pull.HeadRef, number, pull.HeadRepo)
+		} else if err = oc.github.DeleteBranch(pull.HeadRef); err != nil {
+			return nil, err
+		} else {
+			metadata = append(metadata, Metadata{Name: "deleted_branch", Value: pull.HeadRef})
+		}
+	}
+	return metadata, nil
+}
+
+// expandPull expands $PR_NUMBER, $PR_TITLE, $PR_AUTHOR, $PR_HEAD_SHA,
+// $PR_HEAD_REF and $PR_BASE to the fields of pull and anything else to the
+// environment.
+func expandPull(s string, pull *Pull) string {
+	return os.Expand(s, func(name string) string {
+		switch name {
+		case "PR_NUMBER":
+			return strconv.Itoa(pull.Number)
+		case "PR_TITLE":
+			return pull.Title
+		case "PR_AUTHOR":
+			return pull.Author
+		case "PR_HEAD_SHA":
+			return pull.LatestCommitSHA
+		case "PR_HEAD_REF":
+			return pull.HeadRef
+		case "PR_BASE":
+			return pull.BaseRef
+		}
+		return os.Getenv(name)
+	})
+}
+
+func commitStatus(source Source, params OutParams) CommitStatus {
+	statusContext := source.BaseContext
+	if statusContext == "" {
+		statusContext = githubCheckContext
+	}
+	if params.Context != "" {
+		statusContext += "/" + os.ExpandEnv(params.Context)
+	}
+
+	targetURL := params.TargetURL
+	if targetURL == "" && os.Getenv("ATC_EXTERNAL_URL") != "" && os.Getenv("BUILD_ID") != "" {
+		targetURL = defaultTargetURL
+	}
+
+	description := []rune(os.ExpandEnv(params.Description))
+	if len(description) > maxStatusDescriptionLength {
+		description = append(description[:maxStatusDescriptionLength-3], []rune("...")...)
+	}
+
+	return CommitStatus{
+		State:       params.Status,
+		Context:     statusContext,
+		Description: string(description),
+		TargetURL:   os.ExpandEnv(targetURL),
+	}
+}
+
+// newCheckRun builds the check run described by params, named after the
+// status context and linking to the status target URL unless told otherwise.
+// Without a conclusion the status param is taken for one, so pipelines can
+// switch modes without changing their puts.
+func newCheckRun(sourceDir string, status CommitStatus, params OutParams) (CheckRun, error) {
+	checkRun := CheckRun{
+		Name:       os.ExpandEnv(params.CheckName),
+		Status:     params.CheckStatus,
+		Conclusion: params.Conclusion,
+		Title:      os.ExpandEnv(params.Title),
+		DetailsURL: status.TargetURL,
+	}
+
+	if checkRun.Conclusion == "" && params.Status != "" {
+		switch strings.ToLower(params.Status) {
+		case "success":
+			checkRun.Conclusion = "success"
+		case "failure", "error":
+			checkRun.Conclusion = "failure"
+		case "pending":
+		default:
+			return CheckRun{}, fmt.Errorf("%s is not a valid status", params.Status)
+		}
+	}
+
+	if checkRun.Name == "" {
+		checkRun.Name = status.Context
+	}
+
+	if checkRun.Status == "" {
+		checkRun.Status = "in_progress"
+		if checkRun.Conclusion != "" {
+			checkRun.Status = "completed"
+		}
+	}
+
+	if params.SummaryFile != "" {
+		content, err := ioutil.ReadFile(path.Join(sourceDir, params.SummaryFile))
+		if err != nil {
+			return CheckRun{}, fmt.Errorf("reading summary_file: %+v", err)
+		}
+		checkRun.Summary = string(content)
+	}
+
+	if params.TextFile != "" {
+		content, err := ioutil.ReadFile(path.Join(sourceDir, params.TextFile))
+		if err != nil {
+			return CheckRun{}, fmt.Errorf("reading text_file: %+v", err)
+		}
+		checkRun.Text = string(content)
+	}
+
+	if params.AnnotationsFile != "" {
+		content, err := ioutil.ReadFile(path.Join(sourceDir, params.AnnotationsFile))
+		if err != nil {
+			return CheckRun{}, fmt.Errorf("reading annotations_file: %+v", err)
+		}
+		if err = json.Unmarshal(content, &checkRun.Annotations); err != nil {
+			return CheckRun{}, fmt.Errorf("parsing annotations_file: %+v", err)
+		}
+	}
+
+	// GitHub only accepts an output with both a title and a summary.
+	if checkRun.Title == "" && (checkRun.Summary != "" || len(checkRun.Annotations) > 0) {
+		checkRun.Title = checkRun.Name
+	}
+	if checkRun.Summary == "" && checkRun.Title != "" {
+		checkRun.Summary = checkRun.Title
+	}
+
+	return checkRun, nil
+}
+
+func (oc *OutCommand) metadata(prNumber string) []Metadata {
+	metadata := []Metadata{}
+
+	number, err := strconv.Atoi(strings.TrimSpace(prNumber))
+	if err != nil {
+		log.Warnf("parsing pr_number %q: %+v", prNumber, err)
+	} else if pull, err := oc.github.GetPR(number); err != nil {
+		log.Warnf("getting pr %d for metadata: %+v", number, err)
+	} else {
+		metadata = pullMetadata(pull)
+	}
+	return metadata
+}
@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	r "pullrequest/resource"
 	"pullrequest/resource/fake"
@@ -44,6 +46,155 @@ var _ = Describe("CheckCommand", func() {
 				Expect(err).ToNot(HaveOccurred())
 				Expect(outResponse.Version).To(Equal(r.Version{Ref: "fake-ref1", PR: "1"}))
 			})
+
+			It("should return the version check emitted", func() {
+				fakeGithub := &fake.FGithub{
+					ListPRResult: []*r.Pull{
+						&r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1", BaseSHA: "fake-base1"},
+					},
+					GetPRResult: &r.Pull{Number: 1, Ref: "fake-sha1", LatestCommitSHA: "fake-sha1", BaseSHA: "fake-base1"},
+					GetCommitResult: map[string]*r.Commit{
+						"fake-sha1": &r.Commit{SHA: "fake-sha1", CommittedAt: time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)},
+					},
+					UpdatePRResult: "fake-sha1",
+				}
+				source := r.Source{VersionTrigger: "base"}
+
+				versions, err := r.NewCheckCommand(fakeGithub).Run(r.CheckRequest{Source: source})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versions).To(HaveLen(1))
+				Expect(versions[0].PushedAt).ToNot(BeEmpty())
+
+				_, err = r.NewInCommand(fakeGithub).Run(fakeSrcDir, r.InRequest{Source: source, Version: versions[0]})
+				Expect(err).ToNot(HaveOccurred())
+
+				outResponse, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: source})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(outResponse.Version).To(Equal(versions[0]))
+			})
+
+			It("should return error when the version file is invalid", func() {
+				err := ioutil.WriteFile(path.Join(fakeSrcDir, "version.json"), []byte("{"), 0644)
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = r.NewOutCommand(&fake.FGithub{UpdatePRResult: "fake-ref1"}).Run(fakeSrcDir, r.OutRequest{})
+				Expect(err).To(MatchError(HavePrefix("parsing version.json")))
+			})
+
+			It("should post the default context", func() {
+				fakeGithub := &fake.FGithub{UpdatePRResult: "fake-ref1"}
+				outCommand := r.NewOutCommand(fakeGithub)
+
+				_, err := outCommand.Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{Status: "success"}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fakeGithub.UpdatePRStatus).To(Equal(r.CommitStatus{
+					State:   "success",
+					Context: "concourse/ci",
+				}))
+			})
+
+			Context("when status params are given", func() {
+				BeforeEach(func() {
+					os.Setenv("ATC_EXTERNAL_URL", "https://ci.example.com")
+					os.Setenv("BUILD_ID", "42")
+					os.Setenv("BUILD_JOB_NAME", "unit")
+				})
+
+				AfterEach(func() {
+					os.Unsetenv("ATC_EXTERNAL_URL")
+					os.Unsetenv("BUILD_ID")
+					os.Unsetenv("BUILD_JOB_NAME")
+				})
+
+				It("should interpolate build metadata", func() {
+					fakeGithub := &fake.FGithub{UpdatePRResult: "fake-ref1"}
+					outCommand := r.NewOutCommand(fakeGithub)
+
+					_, err := outCommand.Run(fakeSrcDir, r.OutRequest{
+						Source: r.Source{BaseContext: "ci"},
+						OutParams: r.OutParams{
+							Status:      "failure",
+							Context:     "$BUILD_JOB_NAME",
+							Description: "job $BUILD_JOB_NAME failed",
+							TargetURL:   "$ATC_EXTERNAL_URL/teams/main/builds/$BUILD_ID",
+						},
+					})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(fakeGithub.UpdatePRStatus).To(Equal(r.CommitStatus{
+						State:       "failure",
+						Context:     "ci/unit",
+						Description: "job unit failed",
+						TargetURL:   "https://ci.example.com/teams/main/builds/42",
+					}))
+				})
+
+				It("should link to the build by default", func() {
+					fakeGithub := &fake.FGithub{UpdatePRResult: "fake-ref1"}
+					outCommand := r.NewOutCommand(fakeGithub)
+
+					_, err := outCommand.Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{Status: "pending"}})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(fakeGithub.UpdatePRStatus.TargetURL).To(Equal("https://ci.example.com/builds/42"))
+				})
+
+				It("should truncate long descriptions", func() {
+					fakeGithub := &fake.FGithub{UpdatePRResult: "fake-ref1"}
+					outCommand := r.NewOutCommand(fakeGithub)
+
+					_, err := outCommand.Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+						Status:      "pending",
+						Description: strings.Repeat("x", 200),
+					}})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(fakeGithub.UpdatePRStatus.Description).To(Equal(strings.Repeat("x", 137) + "..."))
+				})
+			})
+
+			It("should return metadata of the pull", func() {
+				fakeGithub := &fake.FGithub{
+					UpdatePRResult: "fake-ref1",
+					GetPRResult: &r.Pull{
+						Number:          1,
+						Title:           "fake-title",
+						Author:          "fake-author",
+						HTMLURL:         "https://github.com/fake-owner/fake-repo/pull/1",
+						LatestCommitSHA: "fake-sha1",
+						BaseRef:         "master",
+						Labels:          []string{"ready"},
+						UpdatedAt:       time.Date(2018, 6, 1, 12, 30, 0, 0, time.UTC),
+						State:           "open",
+					},
+				}
+				outCommand := r.NewOutCommand(fakeGithub)
+
+				outResponse, err := outCommand.Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{Status: "success"}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(outResponse.Metadata).To(Equal([]r.Metadata{
+					{Name: "pr", Value: "1"},
+					{Name: "title", Value: "fake-title"},
+					{Name: "author", Value: "fake-author"},
+					{Name: "url", Value: "https://github.com/fake-owner/fake-repo/pull/1"},
+					{Name: "head_sha", Value: "fake-sha1"},
+					{Name: "base", Value: "master"},
+					{Name: "labels", Value: "ready"},
+					{Name: "updated_at", Value: "2018-06-01T12:30:00Z"},
+					{Name: "state", Value: "open"},
+					{Name: "merge_commit_sha", Value: ""},
+					{Name: "status", Value: "success"},
+				}))
+			})
+
+			It("should still return the status when getting the pull fails", func() {
+				fakeGithub := &fake.FGithub{
+					UpdatePRResult: "fake-ref1",
+					GetPRError:     errors.New("fake-get-error"),
+				}
+				outCommand := r.NewOutCommand(fakeGithub)
+
+				outResponse, err := outCommand.Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{Status: "pending"}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(outResponse.Metadata).To(Equal([]r.Metadata{{Name: "status", Value: "pending"}}))
+			})
 		})
 
 		Context("when update failed", func() {
@@ -61,6 +212,519 @@ var _ = Describe("CheckCommand", func() {
 		})
 	})
 
+	Context("when commenting", func() {
+		var fakeGithub *fake.FGithub
+
+		BeforeEach(func() {
+			fakeSrcDir = path.Join(os.TempDir(), "fakedir")
+			err = os.MkdirAll(path.Join(fakeSrcDir, "pr"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = os.MkdirAll(path.Join(fakeSrcDir, "results"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "pr", "pr_number"), []byte("1"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "pr", "pr_id"), []byte("fake-ref1"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "results", "summary.md"), []byte("10 passed, $0 failed"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeGithub = &fake.FGithub{UpdatePRResult: "fake-ref1", LoginResult: "ci-bot"}
+		})
+
+		AfterEach(func() {
+			err = os.RemoveAll(fakeSrcDir)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should post an inline comment without a status", func() {
+			os.Setenv("BUILD_ID", "42")
+			defer os.Unsetenv("BUILD_ID")
+
+			outResponse, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:    "pr",
+				Comment: "build $BUILD_ID passed",
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.CreateCommentBody).To(Equal("build 42 passed"))
+			Expect(fakeGithub.UpdatePRStatus).To(Equal(r.CommitStatus{}))
+			Expect(outResponse.Version).To(Equal(r.Version{Ref: "fake-ref1", PR: "1"}))
+			Expect(outResponse.Metadata).To(ContainElement(r.Metadata{Name: "comment", Value: "fake-comment-url"}))
+			Expect(outResponse.Metadata).ToNot(ContainElement(r.Metadata{Name: "status", Value: ""}))
+		})
+
+		It("should post the comment file verbatim along with the status", func() {
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:        "pr",
+				Status:      "success",
+				CommentFile: "results/summary.md",
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.CreateCommentBody).To(Equal("10 passed, $0 failed"))
+			Expect(fakeGithub.UpdatePRStatus.State).To(Equal("success"))
+		})
+
+		It("should edit the comment carrying the marker", func() {
+			fakeGithub.ListCommentsResult = []*r.Comment{
+				{ID: 10, Author: "ci-bot", Body: "<!-- concourse-pullrequest-resource: concourse/ci/unit -->\nold"},
+				{ID: 11, Author: "ci-bot", Body: "<!-- concourse-pullrequest-resource: concourse/ci/lint -->\nold"},
+				{ID: 12, Author: "ci-bot", Body: "unrelated"},
+			}
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:        "pr",
+				Context:     "unit",
+				Comment:     "new",
+				EditComment: true,
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.EditCommentID).To(Equal(int64(10)))
+			Expect(fakeGithub.EditCommentBody).To(Equal("<!-- concourse-pullrequest-resource: concourse/ci/unit -->\nnew"))
+			Expect(fakeGithub.CreateCommentBody).To(BeEmpty())
+		})
+
+		It("should not edit marked comments of others", func() {
+			fakeGithub.ListCommentsResult = []*r.Comment{
+				{ID: 10, Author: "ci-bot", Body: "<!-- concourse-pullrequest-resource: concourse/ci -->\nold"},
+				{ID: 11, Author: "alice", Body: "> <!-- concourse-pullrequest-resource: concourse/ci -->\n> old\n\nWhy?"},
+			}
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:        "pr",
+				Comment:     "new",
+				EditComment: true,
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.EditCommentID).To(Equal(int64(10)))
+		})
+
+		It("should return error when getting the login fails", func() {
+			fakeGithub.LoginError = errors.New("fake-login-error")
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:        "pr",
+				Comment:     "new",
+				EditComment: true,
+			}})
+			Expect(err).To(MatchError("commenting on pr: fake-login-error"))
+		})
+
+		It("should create a marked comment when none exists yet", func() {
+			fakeGithub.ListCommentsResult = []*r.Comment{{ID: 12, Body: "unrelated"}}
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:          "pr",
+				Comment:       "new",
+				EditComment:   true,
+				CommentMarker: "summary",
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.EditCommentID).To(BeZero())
+			Expect(fakeGithub.CreateCommentBody).To(Equal("<!-- concourse-pullrequest-resource: summary -->\nnew"))
+		})
+
+		It("should return error when the comment file is missing", func() {
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:        "pr",
+				CommentFile: "results/missing.md",
+			}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("commenting on pr: reading comment_file:"))
+		})
+
+		It("should return error when listing comments fails", func() {
+			fakeGithub.ListCommentsError = errors.New("fake-list-error")
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:        "pr",
+				Comment:     "new",
+				EditComment: true,
+			}})
+			Expect(err).To(MatchError("commenting on pr: fake-list-error"))
+		})
+
+		It("should return error when creating the comment fails", func() {
+			fakeGithub.CreateCommentError = errors.New("fake-create-error")
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:    "pr",
+				Comment: "new",
+			}})
+			Expect(err).To(MatchError("commenting on pr: fake-create-error"))
+		})
+	})
+
+	Context("when labelling", func() {
+		var fakeGithub *fake.FGithub
+
+		BeforeEach(func() {
+			fakeSrcDir = path.Join(os.TempDir(), "fakedir")
+			err = os.MkdirAll(path.Join(fakeSrcDir, "pr"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = os.MkdirAll(path.Join(fakeSrcDir, "results"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "pr", "pr_number"), []byte("1"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "pr", "pr_id"), []byte("fake-ref1"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "results", "labels"), []byte("needs-rebase\n\nflaky\n"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeGithub = &fake.FGithub{UpdatePRResult: "fake-ref1"}
+		})
+
+		AfterEach(func() {
+			err = os.RemoveAll(fakeSrcDir)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should add and remove labels without a status", func() {
+			outResponse, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:             "pr",
+				AddLabels:        []string{"ci-passed"},
+				RemoveLabelsFile: "results/labels",
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.AddedLabels).To(Equal([]string{"ci-passed"}))
+			Expect(fakeGithub.RemovedLabels).To(Equal([]string{"needs-rebase", "flaky"}))
+			Expect(fakeGithub.UpdatePRStatus).To(Equal(r.CommitStatus{}))
+			Expect(outResponse.Version).To(Equal(r.Version{Ref: "fake-ref1", PR: "1"}))
+			Expect(outResponse.Metadata).To(ContainElement(r.Metadata{Name: "added_labels", Value: "ci-passed"}))
+			Expect(outResponse.Metadata).To(ContainElement(r.Metadata{Name: "removed_labels", Value: "needs-rebase, flaky"}))
+		})
+
+		It("should update the status as well when one is given", func() {
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:          "pr",
+				Status:        "success",
+				AddLabels:     []string{"ci-passed"},
+				AddLabelsFile: "results/labels",
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.UpdatePRStatus.State).To(Equal("success"))
+			Expect(fakeGithub.AddedLabels).To(Equal([]string{"ci-passed", "needs-rebase", "flaky"}))
+			Expect(fakeGithub.RemovedLabels).To(BeEmpty())
+		})
+
+		It("should return error when the labels file is missing", func() {
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:          "pr",
+				AddLabelsFile: "results/missing",
+			}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("labelling pr: reading add_labels_file:"))
+			Expect(fakeGithub.AddedLabels).To(BeEmpty())
+		})
+
+		It("should return error when adding labels fails", func() {
+			fakeGithub.AddLabelsError = errors.New("fake-error")
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:      "pr",
+				AddLabels: []string{"ci-passed"},
+			}})
+			Expect(err).To(MatchError("labelling pr: fake-error"))
+		})
+
+		It("should return error when removing a label fails", func() {
+			fakeGithub.RemoveLabelError = errors.New("fake-error")
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{OutParams: r.OutParams{
+				Path:         "pr",
+				RemoveLabels: []string{"needs-rebase"},
+			}})
+			Expect(err).To(MatchError("labelling pr: fake-error"))
+		})
+	})
+
+	Context("when merging", func() {
+		var fakeGithub *fake.FGithub
+		var source r.Source
+
+		BeforeEach(func() {
+			fakeSrcDir = path.Join(os.TempDir(), "fakedir")
+			err = os.MkdirAll(path.Join(fakeSrcDir, "pr"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "pr", "pr_number"), []byte("1"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "pr", "pr_id"), []byte("fake-ref1"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "pr", "pr_last_commit_hash"), []byte("fake-sha1"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+
+			source = r.Source{Owner: "fake-owner", Repo: "fake-repo"}
+			fakeGithub = &fake.FGithub{
+				MergePRResult: "fake-merge-sha",
+				GetPRResult: &r.Pull{
+					Number:          1,
+					Title:           "fake-title",
+					LatestCommitSHA: "fake-sha1",
+					HeadRef:         "fake-branch",
+					HeadRepo:        "fake-owner/fake-repo",
+				},
+			}
+		})
+
+		AfterEach(func() {
+			err = os.RemoveAll(fakeSrcDir)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should merge with the templated commit title and message", func() {
+			os.Setenv("BUILD_ID", "42")
+			defer os.Unsetenv("BUILD_ID")
+
+			outResponse, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: source, OutParams: r.OutParams{
+				Path: "pr",
+				Merge: &r.MergeParams{
+					Method:        "squash",
+					CommitTitle:   "$PR_TITLE (#$PR_NUMBER)",
+					CommitMessage: "merged by build $BUILD_ID",
+				},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.UpdatePRStatus).To(Equal(r.CommitStatus{}))
+			Expect(fakeGithub.MergePRRequest).To(Equal(&r.MergeRequest{
+				Method:        "squash",
+				CommitTitle:   "fake-title (#1)",
+				CommitMessage: "merged by build 42",
+			}))
+			Expect(fakeGithub.DeleteBranchResult).To(BeEmpty())
+			Expect(outResponse.Version).To(Equal(r.Version{Ref: "fake-ref1", PR: "1"}))
+			Expect(outResponse.Metadata).To(ContainElement(r.Metadata{Name: "merge_commit_sha", Value: "fake-merge-sha"}))
+			Expect(outResponse.Metadata).To(ContainElement(r.Metadata{Name: "merge_method", Value: "squash"}))
+		})
+
+		It("should pass the head sha and delete the branch", func() {
+			outResponse, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: source, OutParams: r.OutParams{
+				Path:  "pr",
+				Merge: &r.MergeParams{RequireHeadSHA: true, DeleteBranch: true},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.MergePRRequest.SHA).To(Equal("fake-sha1"))
+			Expect(fakeGithub.DeleteBranchResult).To(Equal("fake-branch"))
+			Expect(outResponse.Metadata).To(ContainElement(r.Metadata{Name: "merge_method", Value: "merge"}))
+			Expect(outResponse.Metadata).To(ContainElement(r.Metadata{Name: "deleted_branch", Value: "fake-branch"}))
+		})
+
+		It("should not delete the branch of a fork", func() {
+			fakeGithub.GetPRResult.HeadRepo = "someone/fake-repo"
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: source, OutParams: r.OutParams{
+				Path:  "pr",
+				Merge: &r.MergeParams{DeleteBranch: true},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.MergePRRequest).ToNot(BeNil())
+			Expect(fakeGithub.DeleteBranchResult).To(BeEmpty())
+		})
+
+		It("should refuse to merge when the pr moved", func() {
+			fakeGithub.GetPRResult.LatestCommitSHA = "fake-sha2"
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: source, OutParams: r.OutParams{
+				Path:  "pr",
+				Merge: &r.MergeParams{RequireHeadSHA: true},
+			}})
+			Expect(err).To(MatchError("merging pr: pr 1 moved from fake-sha1 to fake-sha2 since it was fetched, not merging"))
+			Expect(fakeGithub.MergePRRequest).To(BeNil())
+		})
+
+		It("should return error for an invalid method", func() {
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: source, OutParams: r.OutParams{
+				Path:  "pr",
+				Merge: &r.MergeParams{Method: "fake-method"},
+			}})
+			Expect(err).To(MatchError("merging pr: fake-method is not a valid merge method"))
+		})
+
+		It("should return error when merging fails", func() {
+			fakeGithub.MergePRError = errors.New("fake-error")
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: source, OutParams: r.OutParams{
+				Path:  "pr",
+				Merge: &r.MergeParams{DeleteBranch: true},
+			}})
+			Expect(err).To(MatchError("merging pr: fake-error"))
+			Expect(fakeGithub.DeleteBranchResult).To(BeEmpty())
+		})
+
+		It("should return error when deleting the branch fails", func() {
+			fakeGithub.DeleteBranchError = errors.New("fake-error")
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: source, OutParams: r.OutParams{
+				Path:  "pr",
+				Merge: &r.MergeParams{DeleteBranch: true},
+			}})
+			Expect(err).To(MatchError("merging pr: fake-error"))
+		})
+	})
+
+	Context("when mode is check_run", func() {
+		appSource := r.Source{AppID: 1, InstallationID: 2, PrivateKey: "fake-private-key"}
+
+		var fakeGithub *fake.FGithub
+
+		BeforeEach(func() {
+			fakeSrcDir = path.Join(os.TempDir(), "fakedir")
+			err = os.MkdirAll(path.Join(fakeSrcDir, "pr"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = os.MkdirAll(path.Join(fakeSrcDir, "results"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "pr", "pr_number"), []byte("1"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "results", "summary.md"), []byte("fake-summary"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "results", "text.md"), []byte("fake-text"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "results", "annotations.json"), []byte(`[
+				{"path":"main.go","start_line":3,"end_line":4,"annotation_level":"failure","message":"fake-message"}
+			]`), 0777)
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeGithub = &fake.FGithub{UpdateCheckRunResult: "fake-ref1"}
+		})
+
+		AfterEach(func() {
+			err = os.RemoveAll(fakeSrcDir)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should return error without a GitHub App", func() {
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{
+				Source:    r.Source{AccessToken: "fake-token"},
+				OutParams: r.OutParams{Path: "pr", Mode: "check_run", CheckName: "unit", Status: "success"},
+			})
+			Expect(err).To(MatchError("mode check_run needs app_id, installation_id and private_key, only GitHub Apps can create check runs"))
+			Expect(fakeGithub.UpdateCheckRunCheckRun).To(Equal(r.CheckRun{}))
+		})
+
+		It("should update the check run from files", func() {
+			outResponse, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: appSource, OutParams: r.OutParams{
+				Path:            "pr",
+				Mode:            "check_run",
+				CheckName:       "unit",
+				Conclusion:      "failure",
+				Title:           "fake-title",
+				SummaryFile:     "results/summary.md",
+				TextFile:        "results/text.md",
+				AnnotationsFile: "results/annotations.json",
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(outResponse.Version).To(Equal(r.Version{Ref: "fake-ref1", PR: "1"}))
+			Expect(fakeGithub.UpdatePRStatus).To(Equal(r.CommitStatus{}))
+			Expect(fakeGithub.UpdateCheckRunCheckRun).To(Equal(r.CheckRun{
+				Name:       "unit",
+				Status:     "completed",
+				Conclusion: "failure",
+				Title:      "fake-title",
+				Summary:    "fake-summary",
+				Text:       "fake-text",
+				Annotations: []r.CheckRunAnnotation{{
+					Path:            "main.go",
+					StartLine:       3,
+					EndLine:         4,
+					AnnotationLevel: "failure",
+					Message:         "fake-message",
+				}},
+			}))
+			Expect(outResponse.Metadata).To(ContainElement(r.Metadata{Name: "check_run", Value: "unit"}))
+			Expect(outResponse.Metadata).To(ContainElement(r.Metadata{Name: "check_status", Value: "completed"}))
+			Expect(outResponse.Metadata).To(ContainElement(r.Metadata{Name: "conclusion", Value: "failure"}))
+		})
+
+		It("should default to an in progress run named after the context", func() {
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: appSource, OutParams: r.OutParams{
+				Path:    "pr",
+				Mode:    "check_run",
+				Context: "unit",
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.UpdateCheckRunCheckRun).To(Equal(r.CheckRun{
+				Name:   "concourse/ci/unit",
+				Status: "in_progress",
+			}))
+		})
+
+		It("should take the status for a conclusion", func() {
+			for status, conclusion := range map[string]string{"success": "success", "failure": "failure", "error": "failure"} {
+				_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: appSource, OutParams: r.OutParams{
+					Path:   "pr",
+					Mode:   "check_run",
+					Status: status,
+				}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fakeGithub.UpdateCheckRunCheckRun.Status).To(Equal("completed"))
+				Expect(fakeGithub.UpdateCheckRunCheckRun.Conclusion).To(Equal(conclusion))
+			}
+		})
+
+		It("should keep a pending status in progress", func() {
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: appSource, OutParams: r.OutParams{
+				Path:   "pr",
+				Mode:   "check_run",
+				Status: "pending",
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.UpdateCheckRunCheckRun.Status).To(Equal("in_progress"))
+			Expect(fakeGithub.UpdateCheckRunCheckRun.Conclusion).To(BeEmpty())
+		})
+
+		It("should prefer the conclusion over the status", func() {
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: appSource, OutParams: r.OutParams{
+				Path:       "pr",
+				Mode:       "check_run",
+				Status:     "success",
+				Conclusion: "neutral",
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeGithub.UpdateCheckRunCheckRun.Conclusion).To(Equal("neutral"))
+		})
+
+		It("should return error for an invalid status", func() {
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: appSource, OutParams: r.OutParams{
+				Path:   "pr",
+				Mode:   "check_run",
+				Status: "fake-status",
+			}})
+			Expect(err).To(MatchError("fake-status is not a valid status"))
+		})
+
+		It("should return error when the annotations file is invalid", func() {
+			err = ioutil.WriteFile(path.Join(fakeSrcDir, "results", "annotations.json"), []byte("fake-json"), 0777)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: appSource, OutParams: r.OutParams{
+				Path:            "pr",
+				Mode:            "check_run",
+				AnnotationsFile: "results/annotations.json",
+			}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("parsing annotations_file:"))
+		})
+
+		It("should return error when updating the check run fails", func() {
+			fakeGithub.UpdateCheckRunError = errors.New("fake-error")
+
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: appSource, OutParams: r.OutParams{
+				Path: "pr",
+				Mode: "check_run",
+			}})
+			Expect(err).To(MatchError("updating check run: fake-error"))
+		})
+
+		It("should return error for an unknown mode", func() {
+			_, err := r.NewOutCommand(fakeGithub).Run(fakeSrcDir, r.OutRequest{Source: appSource, OutParams: r.OutParams{
+				Path: "pr",
+				Mode: "fake-mode",
+			}})
+			Expect(err).To(MatchError("fake-mode is not a valid mode"))
+		})
+	})
+
 	Context("when pr_number is not there", func() {
 		BeforeEach(func() {
 			fakeSrcDir = path.Join(os.TempDir(), "fakedir")
@@ -0,0 +1,126 @@
+package resource
+
+import (
+	"strconv"
+)
+
+// The review states written by in, see writeReviewsToFile.
+const (
+	reviewStateApproved         = "approved"
+	reviewStateChangesRequested = "changes_requested"
+	reviewStatePending          = "pending"
+)
+
+// trustedAssociations are the author associations of reviewers with write
+// access to the repository.
+var trustedAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR"}
+
+// latestReviews returns the latest approval, change request or dismissal of
+// every reviewer with write access, in the order they were submitted.
+// Comments do not change the verdict of a reviewer.
+func latestReviews(reviews []*Review) []*Review {
+	latest := map[string]int{}
+	verdicts := []*Review{}
+	for _, review := range reviews {
+		if !containsString(trustedAssociations, review.AuthorAssociation) {
+			continue
+		}
+
+		switch review.State {
+		case "APPROVED", "CHANGES_REQUESTED", "DISMISSED":
+		default:
+			continue
+		}
+
+		if i, ok := latest[review.Author]; ok {
+			verdicts[i] = nil
+		}
+		latest[review.Author] = len(verdicts)
+		verdicts = append(verdicts, review)
+	}
+
+	result := []*Review{}
+	for _, review := range verdicts {
+		if review != nil {
+			result = append(result, review)
+		}
+	}
+	return result
+}
+
+// reviewVerdict returns the reviewers who approved and who requested changes,
+// going by their latest review.
+func reviewVerdict(reviews []*Review) ([]string, []string) {
+	approvers, requesters := []string{}, []string{}
+	for _, review := range latestReviews(reviews) {
+		switch review.State {
+		case "APPROVED":
+			approvers = append(approvers, review.Author)
+		case "CHANGES_REQUESTED":
+			requesters = append(requesters, review.Author)
+		}
+	}
+	return approvers, requesters
+}
+
+func reviewState(approvers, requesters []string) string {
+	switch {
+	case len(requesters) > 0:
+		return reviewStateChangesRequested
+	case len(approvers) > 0:
+		return reviewStateApproved
+	default:
+		return reviewStatePending
+	}
+}
+
+// reviewApproved tells whether pull has the approvals source requires. A
+// pending change request outweighs any number of approvals.
+func reviewApproved(g Github, source Source, pull *Pull) (bool, error) {
+	if !source.RequireReviewApproval {
+		return true, nil
+	}
+
+	minApprovals := source.MinApprovals
+	if minApprovals <= 0 {
+		minApprovals = 1
+	}
+
+	reviews, err := g.ListReviews(pull.Number)
+	if err != nil {
+		return false, err
+	}
+
+	approvers, requesters := reviewVerdict(reviews)
+	return len(requesters) == 0 && len(approvers) >= minApprovals, nil
+}
+
+func writeReviewsToFile(destDir string, reviews []*Review) error {
+	approvers, requesters := reviewVerdict(reviews)
+
+	if err := writeToFile(destDir, "pr_review_state", reviewState(approvers, requesters)); err != nil {
+		return err
+	}
+
+	var approvedBy string
+	for _, approver := range approvers {
+		approvedBy += approver + "\n"
+	}
+	if err := writeToFile(destDir, "pr_approved_by", approvedBy); err != nil {
+		return err
+	}
+
+	return writeToFile(destDir, "pr_approvals", strconv.Itoa(len(approvers)))
+}
+
+// approvedHead tells whether a reviewer with write access approved headSHA
+// and did not change their mind since. Approvals of earlier commits do not
+// count, they did not see what is about to run.
+func approvedHead(reviews []*Review, headSHA string) bool {
+	for _, review := range latestReviews(reviews) {
+		if review.State == "APPROVED" && review.CommitID == headSHA {
+			return true
+		}
+	}
+	return false
+}
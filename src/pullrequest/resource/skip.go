@@ -0,0 +1,45 @@
+package resource
+
+import (
+	"strings"
+)
+
+// defaultSkipMarkers are used unless source sets skip_markers, an empty list
+// turns skipping off.
+var defaultSkipMarkers = []string{"[ci skip]", "[skip ci]"}
+
+// skipRequested tells whether the head commit message of pull, or with
+// skip_markers_in_pr its title or body, holds one of the skip markers.
+func skipRequested(g Github, source Source, pull *Pull) (bool, error) {
+	markers := source.SkipMarkers
+	if markers == nil {
+		markers = defaultSkipMarkers
+	}
+
+	if len(markers) == 0 {
+		return false, nil
+	}
+
+	if source.SkipMarkersInPR && (containsAny(pull.Title, markers) || containsAny(pull.Body, markers)) {
+		return true, nil
+	}
+
+	if pull.LatestCommitSHA == "" {
+		return false, nil
+	}
+
+	head, err := g.GetCommit(pull.LatestCommitSHA)
+	if err != nil {
+		return false, err
+	}
+	return containsAny(head.Message, markers), nil
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if sub != "" && strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
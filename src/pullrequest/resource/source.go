@@ -7,12 +7,56 @@ type Source struct {
 	Repo        string `json:"repo"`
 	Owner       string `json:"owner"`
 	APIURL      string `json:"api_endpoint"`
+	APIMode     string `json:"api_mode"`
+	PerPage     int    `json:"per_page"`
+	MaxPRs      int    `json:"max_prs"`
+
+	AppID          int64  `json:"app_id"`
+	InstallationID int64  `json:"installation_id"`
+	PrivateKey     string `json:"private_key"`
+
+	MaxRetryWait string `json:"max_retry_wait"`
+	CacheDir     string `json:"cache_dir"`
+	DisableCache bool   `json:"disable_cache"`
+
+	BaseBranch     string   `json:"base_branch"`
+	RequiredLabels []string `json:"required_labels"`
+	IgnoreLabels   []string `json:"ignore_labels"`
+	Paths          []string `json:"paths"`
+	IgnorePaths    []string `json:"ignore_paths"`
+	IgnoreDrafts   bool     `json:"ignore_drafts"`
+	States         []string `json:"states"`
+
+	ForkPolicy string `json:"fork_policy"`
+	ForkLabel  string `json:"fork_label"`
+
+	TrustedAuthors []string `json:"trusted_authors"`
+	TrustedTeams   []string `json:"trusted_teams"`
+	MinPermission  string   `json:"min_permission"`
+
+	RequireReviewApproval bool `json:"require_review_approval"`
+	MinApprovals          int  `json:"min_approvals"`
+
+	SkipMarkers     []string `json:"skip_markers"`
+	SkipMarkersInPR bool     `json:"skip_markers_in_pr"`
+
+	CommentTrigger            string `json:"comment_trigger"`
+	CommentTriggerTrustedOnly bool   `json:"comment_trigger_trusted_only"`
+
+	BaseContext string `json:"base_context"`
+
+	VersionTrigger string `json:"version_trigger"`
 }
 
 // Version is
 type Version struct {
-	Ref string `json:"ref"`
-	PR  string `json:"pr"`
+	Ref         string `json:"ref"`
+	PR          string `json:"pr"`
+	BaseSHA     string `json:"base_sha,omitempty"`
+	UpdatedAt   string `json:"updated_at,omitempty"`
+	PushedAt    string `json:"pushed_at,omitempty"`
+	CommentID   string `json:"comment_id,omitempty"`
+	CommentedAt string `json:"commented_at,omitempty"`
 }
 
 // Metadata is
@@ -38,6 +82,10 @@ type InParams struct {
 	Globs                []string `json:"globs"`
 	IncludeSourceTarball bool     `json:"include_source_tarball"`
 	IncludeSourceZip     bool     `json:"include_source_zip"`
+	Depth                int      `json:"depth"`
+	FetchBase            bool     `json:"fetch_base"`
+	Submodules           string   `json:"submodules"`
+	Checkout             string   `json:"checkout"`
 }
 
 // InRequest is
@@ -60,8 +108,41 @@ func NewInRequest() InRequest {
 
 // OutParams is
 type OutParams struct {
-	Status string `json:"status"`
-	Path   string `json:"path"`
+	Status      string `json:"status"`
+	Path        string `json:"path"`
+	Context     string `json:"context"`
+	Description string `json:"description"`
+	TargetURL   string `json:"target_url"`
+
+	Comment       string `json:"comment"`
+	CommentFile   string `json:"comment_file"`
+	EditComment   bool   `json:"edit_comment"`
+	CommentMarker string `json:"comment_marker"`
+
+	Mode            string `json:"mode"`
+	CheckName       string `json:"check_name"`
+	CheckStatus     string `json:"check_status"`
+	Conclusion      string `json:"conclusion"`
+	Title           string `json:"title"`
+	SummaryFile     string `json:"summary_file"`
+	TextFile        string `json:"text_file"`
+	AnnotationsFile string `json:"annotations_file"`
+
+	AddLabels        []string `json:"add_labels"`
+	AddLabelsFile    string   `json:"add_labels_file"`
+	RemoveLabels     []string `json:"remove_labels"`
+	RemoveLabelsFile string   `json:"remove_labels_file"`
+
+	Merge *MergeParams `json:"merge"`
+}
+
+// MergeParams is
+type MergeParams struct {
+	Method         string `json:"method"`
+	CommitTitle    string `json:"commit_title"`
+	CommitMessage  string `json:"commit_message"`
+	RequireHeadSHA bool   `json:"require_head_sha"`
+	DeleteBranch   bool   `json:"delete_branch"`
 }
 
 // OutRequest is
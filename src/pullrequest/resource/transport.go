@@ -0,0 +1,168 @@
+package resource
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMaxRetryWait = 5 * time.Minute
+	initialBackoff      = 500 * time.Millisecond
+	maxBackoff          = 30 * time.Second
+)
+
+// retryTransport retries requests GitHub turned away because the rate limit
+// was exceeded, waiting until it resets or for as long as Retry-After says,
+// and requests that failed with a server error, backing off exponentially.
+// The time spent waiting is bounded by maxWait over all requests, once it is
+// used up responses are returned as they are.
+type retryTransport struct {
+	base    http.RoundTripper
+	maxWait time.Duration
+
+	mu          sync.Mutex
+	waited      time.Duration
+	warnedQuota bool
+}
+
+func newRetryTransport(base http.RoundTripper, maxWait time.Duration) *retryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &retryTransport{base: base, maxWait: maxWait}
+}
+
+// maxRetryWait parses the max_retry_wait of source.
+func maxRetryWait(source Source) (time.Duration, error) {
+	if source.MaxRetryWait == "" {
+		return defaultMaxRetryWait, nil
+	}
+
+	wait, err := time.ParseDuration(source.MaxRetryWait)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("%s is not a valid max_retry_wait", source.MaxRetryWait)
+	}
+	return wait, nil
+}
+
+// RoundTrip is
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := initialBackoff
+	for {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		t.logQuota(resp)
+
+		wait, retry := retryAfter(req, resp, backoff)
+		if !retry || !t.reserve(wait) {
+			return resp, nil
+		}
+
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+
+			// Requests must not be modified, per the RoundTripper contract.
+			retry := *req
+			retry.Body = body
+			req = &retry
+		}
+
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		log.Warnf("%s %s returned %s, retrying in %s", req.Method, req.URL.Path, resp.Status, wait)
+		time.Sleep(wait)
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// retryAfter tells whether req is worth retrying after resp and how long to
+// wait before doing so. Server errors are only retried for requests that can
+// safely be repeated, rejected requests were never processed.
+func retryAfter(req *http.Request, resp *http.Response, backoff time.Duration) (time.Duration, bool) {
+	if req.Body != nil && req.GetBody == nil {
+		return 0, false
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests:
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+			if err != nil {
+				return backoff, true
+			}
+
+			// Resets are given in whole seconds, wait for the next one.
+			wait := time.Until(time.Unix(reset, 0)) + time.Second
+			if wait < 0 {
+				wait = 0
+			}
+			return wait, true
+		}
+
+		return backoff, resp.StatusCode == http.StatusTooManyRequests
+	case resp.StatusCode >= 500:
+		switch req.Method {
+		case "GET", "HEAD", "OPTIONS":
+			return backoff, true
+		}
+	}
+	return 0, false
+}
+
+// reserve takes wait from what is left of maxWait, which is false when not
+// enough is left.
+func (t *retryTransport) reserve(wait time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.waited+wait > t.maxWait {
+		return false
+	}
+	t.waited += wait
+	return true
+}
+
+// logQuota warns once when less than a tenth of the rate limit is left.
+func (t *retryTransport) logQuota(resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	if err != nil || remaining*10 >= limit {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.warnedQuota {
+		return
+	}
+	t.warnedQuota = true
+
+	reset := "unknown"
+	if seconds, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		reset = time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+	}
+	log.Warnf("%d of %d github api requests left until the rate limit resets at %s", remaining, limit, reset)
+}
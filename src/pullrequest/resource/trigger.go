@@ -0,0 +1,68 @@
+package resource
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+func commentTrigger(source Source) (*regexp.Regexp, error) {
+	if source.CommentTrigger == "" {
+		return nil, nil
+	}
+
+	pattern, err := regexp.Compile(source.CommentTrigger)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid comment_trigger: %+v", source.CommentTrigger, err)
+	}
+	return pattern, nil
+}
+
+// triggerComment returns the latest comment matching pattern that was made
+// after since, so comments only trigger builds of the commits they were made
+// on. With trustedOnly, comments of users without write access are ignored.
+func triggerComment(comments []*Comment, pattern *regexp.Regexp, trustedOnly bool, since time.Time) *Comment {
+	var latest *Comment
+	for _, comment := range comments {
+		if !comment.CreatedAt.After(since) {
+			continue
+		}
+
+		if trustedOnly && !containsString(trustedAssociations, comment.AuthorAssociation) {
+			continue
+		}
+
+		if !pattern.MatchString(comment.Body) {
+			continue
+		}
+
+		if latest == nil || !comment.CreatedAt.Before(latest.CreatedAt) {
+			latest = comment
+		}
+	}
+	return latest
+}
+
+// findComment returns the comment with the given id, which is nil when it
+// was deleted in the meantime.
+func findComment(comments []*Comment, id string) *Comment {
+	for _, comment := range comments {
+		if strconv.FormatInt(comment.ID, 10) == id {
+			return comment
+		}
+	}
+	return nil
+}
+
+func writeTriggerCommentToFile(destDir string, comment *Comment) error {
+	var body, author string
+	if comment != nil {
+		body, author = comment.Body, comment.Author
+	}
+
+	if err := writeToFile(destDir, "pr_trigger_comment", body); err != nil {
+		return err
+	}
+	return writeToFile(destDir, "pr_trigger_comment_author", author)
+}
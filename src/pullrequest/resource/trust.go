@@ -0,0 +1,66 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+)
+
+// permissionLevels orders the collaborator permissions, see
+// Source.MinPermission.
+var permissionLevels = map[string]int{
+	"none":  0,
+	"read":  1,
+	"write": 2,
+	"admin": 3,
+}
+
+// authorTrusted tells whether the author of pull is trusted by source: listed
+// in trusted_authors, a member of one of trusted_teams or a collaborator
+// with at least min_permission. Without any of those everybody is trusted.
+func authorTrusted(g Github, source Source, pull *Pull) (bool, error) {
+	minLevel, ok := permissionLevels[source.MinPermission]
+	if source.MinPermission != "" && (!ok || minLevel == 0) {
+		return false, fmt.Errorf("%s is not a valid min_permission", source.MinPermission)
+	}
+
+	if len(source.TrustedAuthors) == 0 && len(source.TrustedTeams) == 0 && source.MinPermission == "" {
+		return true, nil
+	}
+
+	if pull.Author == "" {
+		return false, nil
+	}
+
+	for _, author := range source.TrustedAuthors {
+		if strings.EqualFold(author, pull.Author) {
+			return true, nil
+		}
+	}
+
+	for _, team := range source.TrustedTeams {
+		parts := strings.SplitN(team, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return false, fmt.Errorf("%s is not a valid team, expected org/team", team)
+		}
+
+		member, err := g.IsTeamMember(parts[0], parts[1], pull.Author)
+		if err != nil {
+			return false, err
+		}
+		if member {
+			return true, nil
+		}
+	}
+
+	if source.MinPermission != "" {
+		permission, err := g.GetPermission(pull.Author)
+		if err != nil {
+			return false, err
+		}
+		if permissionLevels[permission] >= minLevel {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
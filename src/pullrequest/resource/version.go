@@ -0,0 +1,158 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The events creating a new version of a pull, see Source.VersionTrigger.
+const (
+	versionTriggerCommit = "commit"
+	versionTriggerBase   = "base"
+	versionTriggerUpdate = "update"
+)
+
+// versionFile is where get writes the version it fetched, for put to
+// return the very same version.
+const versionFile = "version.json"
+
+// legacyRefPattern matches refs of older versions of this resource, which
+// combined a short head sha with the time the pull was last updated.
+var legacyRefPattern = regexp.MustCompile(`^([0-9a-f]{7})-(\d{4}-\d{2}-\d{2}T.*)$`)
+
+func versionTrigger(source Source) (string, error) {
+	switch source.VersionTrigger {
+	case "", versionTriggerCommit:
+		return versionTriggerCommit, nil
+	case versionTriggerBase, versionTriggerUpdate:
+		return source.VersionTrigger, nil
+	default:
+		return "", fmt.Errorf("%s is not a valid version_trigger", source.VersionTrigger)
+	}
+}
+
+// newVersion identifies pull by its head commit, adding the base commit or
+// the update time when trigger asks for those to create new versions too,
+// and the comment that triggered a build of it, if any. Unless the update
+// time is used, the version carries the time pull was pushed to, as its sort
+// key.
+func newVersion(pull *Pull, trigger string) Version {
+	version := Version{
+		Ref: pull.Ref,
+		PR:  strconv.Itoa(pull.Number),
+	}
+
+	switch trigger {
+	case versionTriggerBase:
+		version.BaseSHA = pull.BaseSHA
+	case versionTriggerUpdate:
+		if !pull.UpdatedAt.IsZero() {
+			version.UpdatedAt = pull.UpdatedAt.Format(time.RFC3339)
+		}
+	}
+
+	if trigger != versionTriggerUpdate && !pull.PushedAt.IsZero() {
+		version.PushedAt = pull.PushedAt.UTC().Format(time.RFC3339)
+	}
+
+	if pull.TriggerComment != nil {
+		version.CommentID = strconv.FormatInt(pull.TriggerComment.ID, 10)
+		version.CommentedAt = pull.TriggerComment.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	return version
+}
+
+// sortKey returns the time v sorts by, which is false for versions that do
+// not carry one. Versions triggered by a comment sort by the comment when it
+// is later.
+func (v Version) sortKey() (time.Time, bool) {
+	key := v.PushedAt
+	if key == "" {
+		key = v.UpdatedAt
+	}
+	if legacy := legacyRefPattern.FindStringSubmatch(v.Ref); key == "" && legacy != nil {
+		key = legacy[2]
+	}
+
+	t, err := time.Parse(time.RFC3339, key)
+	if commented, cerr := time.Parse(time.RFC3339, v.CommentedAt); cerr == nil && (err != nil || commented.After(t)) {
+		return commented, true
+	}
+	return t, err == nil
+}
+
+// newerThan orders versions by sort key, breaking ties by pr number and ref
+// so the order is stable.
+func (v Version) newerThan(other Version, key, otherKey time.Time) bool {
+	if !key.Equal(otherKey) {
+		return key.After(otherKey)
+	}
+
+	number, _ := strconv.Atoi(v.PR)
+	otherNumber, _ := strconv.Atoi(other.PR)
+	if number != otherNumber {
+		return number > otherNumber
+	}
+	return v.Ref > other.Ref
+}
+
+// matches tells whether v was emitted for the current state of pull.
+func (v Version) matches(pull *Pull, trigger string) bool {
+	if !v.identifies(pull) {
+		return false
+	}
+
+	if legacyRefPattern.MatchString(v.Ref) {
+		return true
+	}
+
+	current := newVersion(pull, trigger)
+	return v.BaseSHA == current.BaseSHA && v.UpdatedAt == current.UpdatedAt && v.CommentID == current.CommentID
+}
+
+// identifies tells whether v was emitted for pull at its current head commit,
+// whatever its base commit or update time. Refs in the legacy format identify
+// any pull whose head sha they are a prefix of.
+func (v Version) identifies(pull *Pull) bool {
+	if v.PR != "" && v.PR != strconv.Itoa(pull.Number) {
+		return false
+	}
+
+	if legacy := legacyRefPattern.FindStringSubmatch(v.Ref); legacy != nil {
+		return strings.HasPrefix(pull.LatestCommitSHA, legacy[1])
+	}
+	return v.Ref == pull.Ref
+}
+
+func writeVersionToFile(destDir string, version Version) error {
+	content, err := json.Marshal(version)
+	if err != nil {
+		return fmt.Errorf("marshalling version: %+v", err)
+	}
+	return writeToFile(destDir, versionFile, string(content))
+}
+
+// readVersionFromFile reads the version get wrote to dir, which is false
+// when it was fetched by a release that did not write one.
+func readVersionFromFile(dir string) (Version, bool, error) {
+	content, err := ioutil.ReadFile(path.Join(dir, versionFile))
+	if os.IsNotExist(err) {
+		return Version{}, false, nil
+	}
+	if err != nil {
+		return Version{}, false, fmt.Errorf("reading %s: %+v", versionFile, err)
+	}
+
+	var version Version
+	if err = json.Unmarshal(content, &version); err != nil {
+		return Version{}, false, fmt.Errorf("parsing %s: %+v", versionFile, err)
+	}
+	return version, true, nil
+}